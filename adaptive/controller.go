@@ -0,0 +1,145 @@
+package adaptive
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+	"github.com/shirou/gopsutil/cpu"
+)
+
+// Controller scales the bucket rates by feedback from the backend, so the shares don't have to be guessed
+// right: rates are tightened while the backend is struggling, and loosened while there is headroom. The
+// signal is either a backend latency percentile, or the CPU idle time of this machine.
+type Controller struct {
+	scale      float64
+	latencies  []time.Duration
+	signal     string
+	target     time.Duration
+	percentile float64
+	idleTarget float64
+	min        float64
+	max        float64
+	step       float64
+	interval   time.Duration
+	onChange   func(scale float64)
+	sync.Mutex
+}
+
+// Samples beyond this many per interval are dropped, to keep memory bounded.
+const maxSamples = 10000
+
+func NewController(c config.Config, onChange func(scale float64)) *Controller {
+	a := &Controller{
+		scale:    1.0,
+		onChange: onChange,
+	}
+	a.SetConfig(c)
+	go a.ticker()
+	return a
+}
+
+func (a *Controller) SetConfig(c config.Config) {
+	a.Lock()
+	defer a.Unlock()
+	a.signal = c.AdaptiveSignal
+	a.target = time.Duration(c.AdaptiveLatencyTargetMs) * time.Millisecond
+	a.percentile = c.AdaptiveLatencyPercentile
+	a.idleTarget = c.AdaptiveIdleTarget
+	a.min = c.AdaptiveMinScale
+	a.max = c.AdaptiveMaxScale
+	a.step = c.AdaptiveStep
+	a.interval = time.Duration(c.AdaptiveIntervalSec*1000) * time.Millisecond
+}
+
+// Observe records the time the backend took to respond.
+func (a *Controller) Observe(latency time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	if len(a.latencies) < maxSamples {
+		a.latencies = append(a.latencies, latency)
+	}
+}
+
+func (a *Controller) Scale() float64 {
+	a.Lock()
+	defer a.Unlock()
+	return a.scale
+}
+
+func (a *Controller) ticker() {
+	for {
+		a.Lock()
+		interval := a.interval
+		a.Unlock()
+		time.Sleep(interval)
+
+		a.adjust()
+	}
+}
+
+func (a *Controller) adjust() {
+	// Sample the CPU outside the lock, it reads /proc.
+	var idle float64
+	a.Lock()
+	signal := a.signal
+	a.Unlock()
+	if signal == "cpu" {
+		busy, err := cpu.Percent(0, false)
+		if err != nil || len(busy) == 0 {
+			return
+		}
+		idle = 100 - busy[0]
+	}
+
+	a.Lock()
+	// -1 tightens, 1 loosens.
+	direction := 0
+	switch a.signal {
+	case "latency":
+		if len(a.latencies) == 0 {
+			break
+		}
+		p := percentile(a.latencies, a.percentile)
+		if p > a.target {
+			direction = -1
+		} else if p < a.target/2 {
+			direction = 1
+		}
+	case "cpu":
+		if idle < a.idleTarget {
+			direction = -1
+		} else if idle > 2*a.idleTarget {
+			direction = 1
+		}
+	}
+	a.latencies = a.latencies[:0]
+
+	scale := a.scale
+	if direction < 0 {
+		scale *= 1 - a.step
+	} else if direction > 0 {
+		scale *= 1 + a.step
+	}
+	if scale < a.min {
+		scale = a.min
+	}
+	if scale > a.max {
+		scale = a.max
+	}
+	changed := scale != a.scale
+	a.scale = scale
+	a.Unlock()
+
+	if changed && a.onChange != nil {
+		a.onChange(scale)
+	}
+}
+
+// percentile sorts the samples in place.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(float64(len(samples)-1) * p / 100)
+	return samples[i]
+}
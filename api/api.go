@@ -1,17 +1,27 @@
 package api
 
 import (
+	"github.com/mateusz/tempomat/backend"
 	"github.com/mateusz/tempomat/bucket"
+	"github.com/mateusz/tempomat/predict"
 	"time"
 )
 
 type TempomatAPI struct {
-	buckets []bucket.Bucketable
+	buckets   []bucket.Bucketable
+	predictor *predict.Predictor
+	pool      StatusProvider
 }
 
-func NewTempomatAPI(b []bucket.Bucketable) *TempomatAPI {
+type StatusProvider interface {
+	Statuses() []backend.Status
+}
+
+func NewTempomatAPI(b []bucket.Bucketable, p *predict.Predictor, pool StatusProvider) *TempomatAPI {
 	return &TempomatAPI{
-		buckets: b,
+		buckets:   b,
+		predictor: p,
+		pool:      pool,
 	}
 }
 
@@ -65,3 +75,43 @@ func repack(b bucket.Bucketable) DumpList {
 	}
 	return l
 }
+
+type PredictionsArgs struct{}
+
+type PredictionList []predict.Estimate
+
+func (a *TempomatAPI) Predictions(args *PredictionsArgs, reply *PredictionList) error {
+	*reply = a.predictor.Estimates()
+	return nil
+}
+
+type RatesArgs struct{}
+
+type RateEntry struct {
+	Bucket string
+	// Effective rate in CPUs, after all scaling.
+	Rate   float64
+}
+
+type RateList []RateEntry
+
+func (a *TempomatAPI) Rates(args *RatesArgs, reply *RateList) error {
+	l := make(RateList, len(a.buckets))
+	for i, b := range a.buckets {
+		l[i] = RateEntry{
+			Bucket: b.String(),
+			Rate:   b.Rate(),
+		}
+	}
+	*reply = l
+	return nil
+}
+
+type BackendsArgs struct{}
+
+type BackendList []backend.Status
+
+func (a *TempomatAPI) Backends(args *BackendsArgs, reply *BackendList) error {
+	*reply = a.pool.Statuses()
+	return nil
+}
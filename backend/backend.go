@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Backend is a single upstream server in the pool.
+type Backend struct {
+	url         *url.URL
+	proxy       *httputil.ReverseProxy
+	healthy     bool
+	outstanding int64
+	requests    int64
+	avgLatency  time.Duration
+	// Peers whose X-Forwarded-Proto is passed on, as they may have terminated TLS.
+	trustedProxiesMap map[string]bool
+	sync.RWMutex
+}
+
+type Status struct {
+	URL         string
+	Healthy     bool
+	Outstanding int64
+	Requests    int64
+	AvgLatency  time.Duration
+}
+
+func newBackend(rawurl string, transport http.RoundTripper, trustedProxiesMap map[string]bool) (*Backend, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid backend URL '%s'", rawurl)
+	}
+
+	b := &Backend{
+		url:               u,
+		healthy:           true,
+		trustedProxiesMap: trustedProxiesMap,
+	}
+	b.proxy = httputil.NewSingleHostReverseProxy(u)
+	director := b.proxy.Director
+	b.proxy.Director = func(r *http.Request) {
+		director(r)
+		if r.TLS != nil {
+			r.Header.Set("X-Forwarded-Proto", "https")
+		} else if !b.trusted(r.RemoteAddr) {
+			r.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	b.proxy.Transport = &latencyTransport{
+		RoundTripper: transport,
+		backend:      b,
+	}
+	return b, nil
+}
+
+func (b *Backend) String() string {
+	return b.url.String()
+}
+
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.Lock()
+	b.outstanding++
+	b.requests++
+	b.Unlock()
+
+	// ReverseProxy panics with http.ErrAbortHandler when the client goes away mid-response.
+	defer func() {
+		b.Lock()
+		b.outstanding--
+		b.Unlock()
+	}()
+	b.proxy.ServeHTTP(w, r)
+}
+
+func (b *Backend) setTrustedProxies(trustedProxiesMap map[string]bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.trustedProxiesMap = trustedProxiesMap
+}
+
+func (b *Backend) trusted(remoteAddr string) bool {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	b.RLock()
+	defer b.RUnlock()
+	return b.trustedProxiesMap[ip]
+}
+
+func (b *Backend) Healthy() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.healthy
+}
+
+func (b *Backend) setHealthy(healthy bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.healthy = healthy
+}
+
+func (b *Backend) Outstanding() int64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.outstanding
+}
+
+func (b *Backend) Status() Status {
+	b.RLock()
+	defer b.RUnlock()
+	return Status{
+		URL:         b.url.String(),
+		Healthy:     b.healthy,
+		Outstanding: b.outstanding,
+		Requests:    b.requests,
+		AvgLatency:  b.avgLatency,
+	}
+}
+
+func (b *Backend) observeLatency(d time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	b.avgLatency -= b.avgLatency / 10
+	b.avgLatency += d / 10
+}
+
+// latencyTransport measures the time until the backend has produced the response headers.
+type latencyTransport struct {
+	http.RoundTripper
+	backend *Backend
+}
+
+func (t *latencyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if err == nil {
+		t.backend.observeLatency(time.Since(start))
+	}
+	return resp, err
+}
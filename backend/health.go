@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (p *Pool) ticker() {
+	for {
+		p.RLock()
+		interval := p.healthInterval
+		p.RUnlock()
+		if interval <= 0 {
+			interval = 5 * time.Second
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(interval):
+		}
+		p.checkHealth()
+	}
+}
+
+func (p *Pool) checkHealth() {
+	p.RLock()
+	path := p.healthCheckPath
+	timeout := p.healthTimeout
+	backends := p.backends
+	p.RUnlock()
+
+	if path == "" {
+		return
+	}
+
+	client := &http.Client{Timeout: timeout}
+	for _, b := range backends {
+		go func(b *Backend) {
+			healthy := false
+			resp, err := client.Get(strings.TrimRight(b.url.String(), "/") + path)
+			if err == nil {
+				healthy = resp.StatusCode < 500
+				resp.Body.Close()
+			}
+			b.setHealthy(healthy)
+		}(b)
+	}
+}
@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Pool balances requests between backends, skipping those that fail health checks.
+type Pool struct {
+	backends        []*Backend
+	balance         string
+	next            int
+	healthCheckPath string
+	healthInterval  time.Duration
+	healthTimeout   time.Duration
+	transport       http.RoundTripper
+	stop            chan struct{}
+	sync.RWMutex
+}
+
+func NewPool(c config.Config, transport http.RoundTripper) (*Pool, error) {
+	p := &Pool{
+		transport: transport,
+		stop:      make(chan struct{}),
+	}
+	if err := p.SetConfig(c); err != nil {
+		return nil, err
+	}
+	go p.ticker()
+	return p, nil
+}
+
+// SetConfig replaces the backend list. Backends that remain configured keep their state.
+func (p *Pool) SetConfig(c config.Config) error {
+	p.Lock()
+	defer p.Unlock()
+
+	existing := make(map[string]*Backend)
+	for _, b := range p.backends {
+		existing[b.String()] = b
+	}
+
+	backends := make([]*Backend, 0)
+	for _, rawurl := range c.BackendsList {
+		b, err := newBackend(rawurl, p.transport, c.TrustedProxiesMap)
+		if err != nil {
+			return err
+		}
+		if old, ok := existing[b.String()]; ok {
+			old.setTrustedProxies(c.TrustedProxiesMap)
+			b = old
+		}
+		backends = append(backends, b)
+	}
+
+	p.backends = backends
+	p.balance = c.Balance
+	p.healthCheckPath = c.HealthCheckPath
+	p.healthInterval = time.Duration(c.HealthCheckIntervalSec*1000) * time.Millisecond
+	p.healthTimeout = time.Duration(c.HealthCheckTimeoutSec*1000) * time.Millisecond
+	return nil
+}
+
+// Stop ends the health checks of a pool that is no longer used.
+func (p *Pool) Stop() {
+	close(p.stop)
+}
+
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b := p.pick()
+	if b == nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	b.ServeHTTP(w, r)
+}
+
+func (p *Pool) pick() *Backend {
+	p.Lock()
+	defer p.Unlock()
+
+	candidates := make([]*Backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.Healthy() {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		// Health checks could be wrong, so rather than failing, try everything.
+		candidates = p.backends
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	if strings.EqualFold(p.balance, "leastconn") {
+		var least *Backend
+		for _, b := range candidates {
+			if least == nil || b.Outstanding() < least.Outstanding() {
+				least = b
+			}
+		}
+		return least
+	}
+
+	p.next = (p.next + 1) % len(candidates)
+	return candidates[p.next]
+}
+
+// Capacity returns the fraction of the pool that is healthy.
+func (p *Pool) Capacity() float64 {
+	p.RLock()
+	defer p.RUnlock()
+
+	if len(p.backends) == 0 {
+		return 1.0
+	}
+	healthy := 0
+	for _, b := range p.backends {
+		if b.Healthy() {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return 1.0 / float64(len(p.backends))
+	}
+	return float64(healthy) / float64(len(p.backends))
+}
+
+func (p *Pool) Statuses() []Status {
+	p.RLock()
+	defer p.RUnlock()
+
+	l := make([]Status, len(p.backends))
+	for i, b := range p.backends {
+		l[i] = b.Status()
+	}
+	return l
+}
@@ -0,0 +1,79 @@
+package bucket
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/ipdb"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// ASN classifies by the network operator, catching cloud-hosted crawlers spread across many subnets of the
+// same provider. Addresses not found in the database are not limited by this bucket.
+type ASN struct {
+	Keyed
+	db                *ipdb.ASN
+	trustedProxiesMap map[string]bool
+}
+
+func NewASN(c config.Config, db *ipdb.ASN) *ASN {
+	b := &ASN{
+		db: db,
+	}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 60
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *ASN) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.ASNCPUs
+	b.trustedProxiesMap = c.TrustedProxiesMap
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *ASN) String() string {
+	return "ASN"
+}
+
+func (b *ASN) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	record, found := b.lookup(r)
+	if !found {
+		return 0, true
+	}
+	return b.reserveN(fmt.Sprintf("AS%d", record.Number), record.String(), start, qty)
+}
+
+func (b *ASN) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	record, found := b.lookup(r)
+	if !found {
+		return 0, true
+	}
+	return b.settleN(fmt.Sprintf("AS%d", record.Number), start, qty)
+}
+
+func (b *ASN) Debt(r *http.Request) time.Duration {
+	record, found := b.lookup(r)
+	if !found {
+		return 0
+	}
+	return b.debt(fmt.Sprintf("AS%d", record.Number))
+}
+
+func (b *ASN) lookup(r *http.Request) (ipdb.ASNRecord, bool) {
+	b.RLock()
+	ip := net.ParseIP(ClientIP(r, b.trustedProxiesMap))
+	b.RUnlock()
+
+	if ip == nil {
+		return ipdb.ASNRecord{}, false
+	}
+	return b.db.Lookup(ip)
+}
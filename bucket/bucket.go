@@ -1,6 +1,8 @@
 package bucket
 
 import (
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -13,6 +15,7 @@ type Bucket struct {
 	delayThreshold time.Duration
 	cpuCount	float64
 	rate           float64
+	scales         map[string]float64
 	hashMaxLen     int
 	sync.RWMutex
 }
@@ -25,6 +28,15 @@ func (b *Bucket) SetConfig(c config.Config) {
 	b.hashMaxLen = c.HashMaxLen
 }
 
+// Product of all factors set via SetScale. Not concurrency safe.
+func (b *Bucket) scale() float64 {
+	f := 1.0
+	for _, s := range b.scales {
+		f *= s
+	}
+	return f
+}
+
 func (b *Bucket) DelayThreshold() time.Duration {
 	b.RLock()
 	defer b.RUnlock()
@@ -51,3 +63,30 @@ func getIPAdressFromHeaders(r *http.Request, m map[string]bool) string {
 	}
 	return ""
 }
+
+// ClientIP returns the address of the client, looking past trusted proxies.
+func ClientIP(r *http.Request, trustedProxiesMap map[string]bool) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		if _, ok := trustedProxiesMap[ip]; ok {
+			headerIp := getIPAdressFromHeaders(r, trustedProxiesMap)
+			if headerIp != "" {
+				ip = headerIp
+			}
+		}
+	}
+	return ip
+}
+
+// clientNetwork returns the network of the client with the given netmask, e.g. "10.0.1.0/24".
+func clientNetwork(r *http.Request, trustedProxiesMap map[string]bool, netmask int) string {
+	ip := ClientIP(r, trustedProxiesMap)
+
+	ipnet := "0.0.0.0/0"
+	_, network, err := net.ParseCIDR(fmt.Sprintf("%s/%d", ip, netmask))
+	// @todo shouldn't this error be handled properly? is it ipv6 compat?
+	if err == nil {
+		ipnet = network.String()
+	}
+	return ipnet
+}
@@ -0,0 +1,73 @@
+package bucket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/challenge"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// ChallengePassed is the lane for clients that came back with a valid challenge cookie. Everyone else passes
+// through. It's keyed by the client IP rather than the cookie, as the cookie is bound to the IP anyway, and a
+// client could otherwise get a fresh allowance by dropping the cookie and passing the challenge again.
+type ChallengePassed struct {
+	Keyed
+	trustedProxiesMap map[string]bool
+}
+
+func NewChallengePassed(c config.Config) *ChallengePassed {
+	b := &ChallengePassed{}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *ChallengePassed) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.ChallengeCPUs
+	b.trustedProxiesMap = c.TrustedProxiesMap
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *ChallengePassed) String() string {
+	return "ChallengePassed"
+}
+
+func (b *ChallengePassed) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, passed := b.key(r)
+	if !passed {
+		return 0, true
+	}
+	return b.reserveN(key, key, start, qty)
+}
+
+func (b *ChallengePassed) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, passed := b.key(r)
+	if !passed {
+		return 0, true
+	}
+	return b.settleN(key, start, qty)
+}
+
+func (b *ChallengePassed) Debt(r *http.Request) time.Duration {
+	key, passed := b.key(r)
+	if !passed {
+		return 0
+	}
+	return b.debt(key)
+}
+
+func (b *ChallengePassed) key(r *http.Request) (key string, passed bool) {
+	if challenge.PassedFromContext(r.Context()) == "" {
+		return "", false
+	}
+	b.RLock()
+	defer b.RUnlock()
+	return ClientIP(r, b.trustedProxiesMap), true
+}
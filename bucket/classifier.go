@@ -0,0 +1,82 @@
+package bucket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Classifier classifies on a single configurable attribute, such as an API key header or a tenant cookie.
+// Requests without the attribute pass through.
+type Classifier struct {
+	Keyed
+	name              string
+	extractor         config.Extractor
+	trustedProxiesMap map[string]bool
+}
+
+func NewClassifier(c config.Config, name string) *Classifier {
+	b := &Classifier{
+		name: name,
+	}
+	b.hash = make(map[string]EntryKeyed)
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+// SetConfig picks up the classifier with the same name. If it has been removed from the config, the bucket
+// keeps its previous settings until restart.
+func (b *Classifier) SetConfig(c config.Config) {
+	b.Bucket.SetConfig(c)
+
+	b.Lock()
+	for _, classifier := range c.Classifiers {
+		if classifier.Name == b.name {
+			b.rate = classifier.CPUs
+			b.burst = classifier.Burst
+			b.hashMaxLen = classifier.HashMaxLen
+			b.extractor = classifier.Extractor
+		}
+	}
+	b.trustedProxiesMap = c.TrustedProxiesMap
+	b.truncate(0)
+	b.Unlock()
+}
+
+func (b *Classifier) String() string {
+	return b.name
+}
+
+func (b *Classifier) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, title := b.key(r)
+	if key == "" {
+		return 0, true
+	}
+	return b.reserveN(key, title, start, qty)
+}
+
+func (b *Classifier) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, _ := b.key(r)
+	if key == "" {
+		return 0, true
+	}
+	return b.settleN(key, start, qty)
+}
+
+func (b *Classifier) Debt(r *http.Request) time.Duration {
+	key, _ := b.key(r)
+	if key == "" {
+		return 0
+	}
+	return b.debt(key)
+}
+
+func (b *Classifier) key(r *http.Request) (key, title string) {
+	b.RLock()
+	defer b.RUnlock()
+
+	value := extract(b.extractor, r, b.trustedProxiesMap)
+	return value, extractorLabel(b.extractor) + "=" + value
+}
@@ -0,0 +1,79 @@
+package bucket
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Composite classifies on several request attributes at once, e.g. "this User-Agent from this /24".
+type Composite struct {
+	Keyed
+	name              string
+	extractors        []config.Extractor
+	trustedProxiesMap map[string]bool
+}
+
+func NewComposite(c config.Config, name string) *Composite {
+	b := &Composite{
+		name: name,
+	}
+	b.hash = make(map[string]EntryKeyed)
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+// SetConfig picks up the composite with the same name. If it has been removed from the config, the bucket
+// keeps its previous settings until restart.
+func (b *Composite) SetConfig(c config.Config) {
+	b.Lock()
+	for _, composite := range c.Composites {
+		if composite.Name == b.name {
+			b.rate = composite.CPUs
+			b.burst = composite.Burst
+			b.extractors = composite.Extractors
+		}
+	}
+	b.trustedProxiesMap = c.TrustedProxiesMap
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *Composite) String() string {
+	return b.name
+}
+
+func (b *Composite) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, title := b.key(r)
+	return b.reserveN(key, title, start, qty)
+}
+
+func (b *Composite) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, _ := b.key(r)
+	return b.settleN(key, start, qty)
+}
+
+func (b *Composite) Debt(r *http.Request) time.Duration {
+	key, _ := b.key(r)
+	return b.debt(key)
+}
+
+func (b *Composite) key(r *http.Request) (key, title string) {
+	b.RLock()
+	defer b.RUnlock()
+
+	values := make([]string, len(b.extractors))
+	labels := make([]string, len(b.extractors))
+	for i, e := range b.extractors {
+		values[i] = extract(e, r, b.trustedProxiesMap)
+		labels[i] = fmt.Sprintf("%s=%s", extractorLabel(e), values[i])
+	}
+	// Values can contain anything, so join them unambiguously.
+	return fmt.Sprintf("%q", values), strings.Join(labels, " + ")
+}
@@ -0,0 +1,61 @@
+package bucket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/crawler"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// VerifiedCrawler is the lane for search engine crawlers confirmed by DNS, keyed by the crawler name. Requests
+// that haven't been verified pass through.
+type VerifiedCrawler struct {
+	Keyed
+}
+
+func NewVerifiedCrawler(c config.Config) *VerifiedCrawler {
+	b := &VerifiedCrawler{}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *VerifiedCrawler) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.CrawlerCPUs
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *VerifiedCrawler) String() string {
+	return "VerifiedCrawler"
+}
+
+func (b *VerifiedCrawler) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	name := crawler.VerifiedFromContext(r.Context())
+	if name == "" {
+		return 0, true
+	}
+	return b.reserveN(name, name, start, qty)
+}
+
+func (b *VerifiedCrawler) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	name := crawler.VerifiedFromContext(r.Context())
+	if name == "" {
+		return 0, true
+	}
+	return b.settleN(name, start, qty)
+}
+
+func (b *VerifiedCrawler) Debt(r *http.Request) time.Duration {
+	name := crawler.VerifiedFromContext(r.Context())
+	if name == "" {
+		return 0
+	}
+	return b.debt(name)
+}
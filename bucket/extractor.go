@@ -0,0 +1,101 @@
+package bucket
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mateusz/tempomat/lib/config"
+	"github.com/mateusz/tempomat/predict"
+)
+
+// extract returns a single attribute of the request, as described by the extractor config. Missing
+// attributes are returned as empty, so that e.g. all clients without a cookie share a key.
+func extract(e config.Extractor, r *http.Request, trustedProxiesMap map[string]bool) string {
+	var value string
+	switch e.Type {
+	case "ip":
+		value = clientNetwork(r, trustedProxiesMap, e.Netmask)
+	case "header":
+		value = r.Header.Get(e.Name)
+	case "cookie":
+		if c, err := r.Cookie(e.Name); err == nil {
+			value = c.Value
+		}
+	case "bearer":
+		value = bearerSubject(r)
+	case "path":
+		if e.Regexp == nil {
+			value = predict.Normalise(r.URL.Path)
+		} else {
+			value = r.URL.Path
+		}
+	}
+
+	if e.Regexp != nil && value != "" {
+		m := e.Regexp.FindStringSubmatch(value)
+		if len(m) > 1 {
+			value = m[1]
+		} else if len(m) == 1 {
+			value = m[0]
+		} else {
+			value = ""
+		}
+	}
+
+	if e.Hashed && value != "" {
+		value = hash(value)
+	}
+	return value
+}
+
+func hash(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:16]
+}
+
+// bearerSubject returns the "sub" claim of a JWT bearer token, or a hash of the whole token if it's opaque, as
+// the token itself is a credential and must not end up in the logs, the doctor or the API. The JWT signature is
+// not verified, as this is only used for classification: a client forging subjects only spreads itself across
+// more keys, the same as it could with any other attribute.
+func bearerSubject(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return ""
+	}
+	token := strings.TrimSpace(auth[7:])
+
+	if token == "" {
+		return ""
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return hash(token)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return hash(token)
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return hash(token)
+	}
+	return claims.Subject
+}
+
+func extractorLabel(e config.Extractor) string {
+	switch e.Type {
+	case "ip":
+		return fmt.Sprintf("ip/%d", e.Netmask)
+	case "header", "cookie":
+		return fmt.Sprintf("%s %s", e.Type, e.Name)
+	case "bearer":
+		return "bearer sub"
+	}
+	return e.Type
+}
@@ -0,0 +1,77 @@
+package bucket
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/ipdb"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Addresses not found in the database share a single entry.
+const unknownCountry = "--"
+
+// Geo classifies by country, e.g. to keep domestic traffic flowing while floods come from abroad. Countries
+// can be given their own share, everyone else gets the default.
+type Geo struct {
+	Keyed
+	db                *ipdb.Country
+	trustedProxiesMap map[string]bool
+}
+
+func NewGeo(c config.Config, db *ipdb.Country) *Geo {
+	b := &Geo{
+		db: db,
+	}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *Geo) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.GeoCPUs
+	b.rates = c.GeoCountryCPUs
+	b.trustedProxiesMap = c.TrustedProxiesMap
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *Geo) String() string {
+	return "Geo"
+}
+
+func (b *Geo) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, title := b.country(r)
+	return b.reserveN(key, title, start, qty)
+}
+
+func (b *Geo) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, _ := b.country(r)
+	return b.settleN(key, start, qty)
+}
+
+func (b *Geo) Debt(r *http.Request) time.Duration {
+	key, _ := b.country(r)
+	return b.debt(key)
+}
+
+func (b *Geo) country(r *http.Request) (key, title string) {
+	b.RLock()
+	ip := net.ParseIP(ClientIP(r, b.trustedProxiesMap))
+	b.RUnlock()
+
+	if ip == nil {
+		return unknownCountry, unknownCountry
+	}
+	record, found := b.db.Lookup(ip)
+	if !found {
+		return unknownCountry, unknownCountry
+	}
+	return record.ISOCode, record.String()
+}
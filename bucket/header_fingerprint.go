@@ -0,0 +1,89 @@
+package bucket
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mateusz/tempomat/fingerprint"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// HeaderFingerprint classifies by the set and order of request headers, plus a few selected values. Bespoke
+// crawlers send a telltale set of headers even when they fake the User-Agent.
+type HeaderFingerprint struct {
+	Keyed
+	values []string
+	ignore map[string]bool
+}
+
+func NewHeaderFingerprint(c config.Config) *HeaderFingerprint {
+	b := &HeaderFingerprint{}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *HeaderFingerprint) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.HeaderFingerprintCPUs
+	b.values = c.HeaderFingerprintValuesList
+	b.ignore = make(map[string]bool)
+	for _, h := range c.HeaderFingerprintIgnoreList {
+		b.ignore[http.CanonicalHeaderKey(h)] = true
+	}
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *HeaderFingerprint) String() string {
+	return "HeaderFingerprint"
+}
+
+func (b *HeaderFingerprint) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, title := b.fingerprint(r)
+	return b.reserveN(key, title, start, qty)
+}
+
+func (b *HeaderFingerprint) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, _ := b.fingerprint(r)
+	return b.settleN(key, start, qty)
+}
+
+func (b *HeaderFingerprint) Debt(r *http.Request) time.Duration {
+	key, _ := b.fingerprint(r)
+	return b.debt(key)
+}
+
+// The title leads with the User-Agent, which the fingerprint deliberately ignores, to make it easier to tell
+// what is behind it.
+func (b *HeaderFingerprint) fingerprint(r *http.Request) (key, title string) {
+	b.RLock()
+	defer b.RUnlock()
+
+	names, ordered := fingerprint.HeaderNames(r)
+	kept := make([]string, 0, len(names))
+	for _, name := range names {
+		if !b.ignore[http.CanonicalHeaderKey(name)] {
+			kept = append(kept, name)
+		}
+	}
+
+	values := make([]string, len(b.values))
+	for i, h := range b.values {
+		values[i] = fmt.Sprintf("%s: %s", h, r.Header.Get(h))
+	}
+
+	order := "sorted"
+	if ordered {
+		order = "ordered"
+	}
+	key = fmt.Sprintf("%s|%s|%s", order, strings.Join(kept, ","), strings.Join(values, "|"))
+	title = fmt.Sprintf("%s | %s | %s", r.UserAgent(), strings.Join(kept, ","), strings.Join(values, "; "))
+	return
+}
@@ -24,7 +24,15 @@ type Bucketable interface {
 	fmt.Stringer
 	Entries() Entries
 	ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool)
+	// SettleN corrects an earlier ReserveN for the same request by qty, which is negative if too much was reserved.
+	SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool)
+	// Debt returns how long the request's key would have to wait for a free reservation, without charging it.
+	Debt(r *http.Request) time.Duration
 	SetConfig(config.Config)
+	// SetScale multiplies the configured rate by a factor. Factors from different sources are multiplied together.
+	SetScale(source string, scale float64)
+	// Rate returns the effective rate in CPUs, after scaling. Keys may have their own rates.
+	Rate() float64
 	DelayThreshold() time.Duration
 }
 
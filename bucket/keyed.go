@@ -0,0 +1,267 @@
+package bucket
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Keyed keeps a rate limiter and usage stats per key. Concrete buckets classify the request into a key
+// and leave the accounting to Keyed.
+type Keyed struct {
+	Bucket
+	hash  map[string]EntryKeyed
+	rates map[string]float64
+	burst float64
+}
+
+func (b *Keyed) Entries() Entries {
+	b.RLock()
+	defer b.RUnlock()
+
+	return b.entries()
+}
+
+func (b *Keyed) entries() Entries {
+	l := make(Entries, len(b.hash))
+	i := 0
+	for _, v := range b.hash {
+		l[i] = v
+		i++
+	}
+	return l
+}
+
+// SetScale multiplies the configured rate by a factor, e.g. to account for lost capacity. Factors from
+// different sources are multiplied together.
+func (b *Keyed) SetScale(source string, scale float64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.scales == nil {
+		b.scales = make(map[string]float64)
+	}
+	if current, ok := b.scales[source]; ok && current == scale {
+		return
+	}
+	b.scales[source] = scale
+
+	for _, e := range b.hash {
+		e.limiter.SetLimit(rate.Limit(b.keyRate(e.key) * 1000))
+	}
+}
+
+func (b *Keyed) Rate() float64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.rate * b.scale()
+}
+
+// keyRate returns the scaled rate for the key, which is the bucket rate unless overridden in rates.
+// Not concurrency safe.
+func (b *Keyed) keyRate(key string) float64 {
+	r := b.rate
+	if keyRate, ok := b.rates[key]; ok {
+		r = keyRate
+	}
+	return r * b.scale()
+}
+
+// setKeyRate overrides the rate of a single key, including its existing limiter. A zero rate blocks the key once
+// its burst is spent. Not concurrency safe.
+func (b *Keyed) setKeyRate(key string, cpus float64) {
+	if b.rates == nil {
+		b.rates = make(map[string]float64)
+	}
+	b.rates[key] = cpus
+	b.updateKeyLimit(key)
+}
+
+// clearKeyRate removes the override set by setKeyRate, returning the key to the bucket rate. Not concurrency safe.
+func (b *Keyed) clearKeyRate(key string) {
+	delete(b.rates, key)
+	b.updateKeyLimit(key)
+}
+
+// Not concurrency safe.
+func (b *Keyed) updateKeyLimit(key string) {
+	if entry, ok := b.hash[EntryKeyed{key: key}.Hash()]; ok {
+		entry.limiter.SetLimit(rate.Limit(b.keyRate(key) * 1000))
+	}
+}
+
+// reserveN charges qty CPU-seconds to the key.
+func (b *Keyed) reserveN(key, title string, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	b.Lock()
+	defer b.Unlock()
+	entry := EntryKeyed{
+		key:   key,
+		title: title,
+	}
+	hash := entry.Hash()
+
+	if _, ok := b.hash[hash]; ok {
+		entry = b.hash[hash]
+	} else {
+		entry.limiter = rate.NewLimiter(rate.Limit(b.keyRate(key)*1000), int(b.burst*1000))
+	}
+
+	rsv := entry.limiter.ReserveN(start, int(qty*1000))
+	if rsv.OK() && rsv.Delay() != rate.InfDuration {
+		ok = true
+		delay = rsv.Delay()
+	} else {
+		ok = false
+		delay = 120 * time.Second
+	}
+
+	var delayRemaining time.Duration
+	elapsed := time.Now().Sub(start)
+	if elapsed <= delay {
+		delayRemaining = delay - elapsed
+	}
+
+	sincePrev := time.Now().Sub(entry.lastUsed)
+	if sincePrev > 0 && sincePrev < time.Minute {
+		entry.avgSincePrev -= entry.avgSincePrev / 10
+		entry.avgSincePrev += sincePrev / 10
+	}
+
+	entry.lastUsed = time.Now()
+	entry.avgWait -= entry.avgWait / 10
+	entry.avgWait += delayRemaining / 10
+
+	cpuSecsPerSec := qty / float64(entry.avgSincePrev.Seconds())
+	if cpuSecsPerSec < 100.0 {
+		entry.avgCpuSecs -= entry.avgCpuSecs / 10
+		entry.avgCpuSecs += cpuSecsPerSec / 10
+	}
+
+	b.hash[hash] = entry
+
+	return
+}
+
+// settleN corrects an earlier reservation for the key by qty CPU-seconds, which may be negative. Unlike reserveN
+// it does not count as a separate request.
+func (b *Keyed) settleN(key string, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	b.Lock()
+	defer b.Unlock()
+	hash := EntryKeyed{key: key}.Hash()
+
+	entry, found := b.hash[hash]
+	if !found {
+		// Nothing to settle, the entry has been truncated in the meantime.
+		return 0, true
+	}
+
+	// The limiter has no API for returning tokens, but reserving a negative amount does just that. Tokens
+	// exceeding the burst are discarded by the limiter on the next reservation.
+	rsv := entry.limiter.ReserveN(start, int(qty*1000))
+	if rsv.OK() && rsv.Delay() != rate.InfDuration {
+		ok = true
+		delay = rsv.Delay()
+	} else {
+		ok = false
+		delay = 120 * time.Second
+	}
+
+	if entry.avgSincePrev > 0 {
+		entry.avgCpuSecs += qty / entry.avgSincePrev.Seconds() / 10
+		if entry.avgCpuSecs < 0 {
+			entry.avgCpuSecs = 0
+		}
+	}
+
+	b.hash[hash] = entry
+
+	return
+}
+
+// debt returns how far the key is over budget, as the time until its limiter is back at zero.
+func (b *Keyed) debt(key string) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	entry, found := b.hash[EntryKeyed{key: key}.Hash()]
+	if !found {
+		return 0
+	}
+	// Reserving nothing doesn't change the balance, but reports the wait.
+	return entry.limiter.ReserveN(time.Now(), 0).Delay()
+}
+
+// Not concurrency safe.
+func (b *Keyed) truncate(truncatedSize int) {
+	entries := b.entries()
+
+	sort.Sort(LastUsedSortEntries(entries))
+	purged := make(Entries, 0, len(entries))
+	for i := 0; i < len(entries); i++ {
+		if time.Now().Sub(entries[i].LastUsed()) < 60*time.Second {
+			purged = append(purged, entries[i])
+		}
+	}
+
+	sort.Sort(AvgWaitSortEntries(purged))
+	newHash := make(map[string]EntryKeyed)
+	for i := 0; i < truncatedSize && i < len(purged); i++ {
+		newHash[purged[i].Hash()] = purged[i].(EntryKeyed)
+	}
+
+	// Note: this will overwrite recently added entries
+	b.hash = newHash
+}
+
+func (b *Keyed) ticker() {
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		b.Lock()
+		b.truncate(b.hashMaxLen)
+		b.Unlock()
+	}
+}
+
+type EntryKeyed struct {
+	key          string
+	title        string
+	lastUsed     time.Time
+	avgWait      time.Duration
+	avgSincePrev time.Duration
+	avgCpuSecs   float64
+	limiter      *rate.Limiter
+}
+
+func (e EntryKeyed) Hash() string {
+	hasher := md5.New()
+	io.WriteString(hasher, e.key)
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+func (e EntryKeyed) LastUsed() time.Time {
+	return e.lastUsed
+}
+
+func (e EntryKeyed) AvgWait() time.Duration {
+	return e.avgWait
+}
+
+func (e EntryKeyed) AvgSincePrev() time.Duration {
+	return e.avgSincePrev
+}
+
+func (e EntryKeyed) AvgCpuSecs() float64 {
+	return e.avgCpuSecs
+}
+
+func (e EntryKeyed) String() string {
+	return fmt.Sprintf("%s, used %.0fs ago", e.title, time.Now().Sub(e.lastUsed).Seconds())
+}
+
+func (e EntryKeyed) Title() string {
+	return e.title
+}
@@ -0,0 +1,147 @@
+package bucket
+
+import (
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// QueryCardinality catches crawlers lost in infinite URL spaces, such as faceted search or pagination, by
+// counting the distinct query strings each client requests for the same path. Clients exploring more than the
+// threshold of variants within the window are limited to the penalty share until they calm down.
+type QueryCardinality struct {
+	Keyed
+	trustedProxiesMap map[string]bool
+	netmask           int
+	threshold         int
+	window            time.Duration
+	penaltyRate       float64
+	// Last time each query variant was seen, by client key and path.
+	variants map[queryPath]map[uint64]time.Time
+	// Penalised client keys, until the given time.
+	penalised map[string]time.Time
+}
+
+type queryPath struct {
+	key  string
+	path string
+}
+
+func NewQueryCardinality(c config.Config) *QueryCardinality {
+	b := &QueryCardinality{}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 30
+	b.SetConfig(c)
+	go b.ticker()
+	go b.sweeper()
+	return b
+}
+
+func (b *QueryCardinality) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.QueryCardinalityCPUs
+	b.penaltyRate = c.QueryCardinalityPenaltyCPUs
+	b.rates = make(map[string]float64)
+	b.netmask = c.QueryCardinalityNetmask
+	b.threshold = c.QueryCardinalityThreshold
+	b.window = time.Duration(c.QueryCardinalityWindowSec*1000) * time.Millisecond
+	b.trustedProxiesMap = c.TrustedProxiesMap
+	b.variants = make(map[queryPath]map[uint64]time.Time)
+	b.penalised = make(map[string]time.Time)
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *QueryCardinality) String() string {
+	return "QueryCardinality"
+}
+
+func (b *QueryCardinality) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key := b.observe(r)
+	return b.reserveN(key, key, start, qty)
+}
+
+func (b *QueryCardinality) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	b.RLock()
+	key := clientNetwork(r, b.trustedProxiesMap, b.netmask)
+	b.RUnlock()
+	return b.settleN(key, start, qty)
+}
+
+func (b *QueryCardinality) Debt(r *http.Request) time.Duration {
+	b.RLock()
+	key := clientNetwork(r, b.trustedProxiesMap, b.netmask)
+	b.RUnlock()
+	return b.debt(key)
+}
+
+// observe records the query variant, penalising the client if it has gone over the threshold.
+func (b *QueryCardinality) observe(r *http.Request) string {
+	b.Lock()
+	defer b.Unlock()
+
+	key := clientNetwork(r, b.trustedProxiesMap, b.netmask)
+	if r.URL.RawQuery == "" || b.threshold <= 0 {
+		return key
+	}
+
+	qp := queryPath{key: key, path: r.URL.Path}
+	seen, found := b.variants[qp]
+	if !found {
+		// Stop tracking new paths rather than growing without bounds. The sweeper frees up space.
+		if len(b.variants) >= b.hashMaxLen*10 {
+			return key
+		}
+		seen = make(map[uint64]time.Time)
+		b.variants[qp] = seen
+	}
+
+	// Encode sorts the parameters, so reordering them doesn't count as a new variant.
+	hasher := fnv.New64a()
+	hasher.Write([]byte(r.URL.Query().Encode()))
+	now := time.Now()
+	seen[hasher.Sum64()] = now
+
+	// Once over, there is no point tracking more variants.
+	if len(seen) > b.threshold {
+		if _, ok := b.penalised[key]; !ok {
+			b.setKeyRate(key, b.penaltyRate)
+		}
+		b.penalised[key] = now.Add(b.window)
+		delete(b.variants, qp)
+	}
+	return key
+}
+
+func (b *QueryCardinality) sweeper() {
+	ticker := time.NewTicker(10 * time.Second)
+	for range ticker.C {
+		b.Lock()
+		b.sweep(time.Now())
+		b.Unlock()
+	}
+}
+
+// sweep forgets variants that have fallen out of the window, and lifts expired penalties. Not concurrency safe.
+func (b *QueryCardinality) sweep(now time.Time) {
+	for qp, seen := range b.variants {
+		for variant, last := range seen {
+			if now.Sub(last) > b.window {
+				delete(seen, variant)
+			}
+		}
+		if len(seen) == 0 {
+			delete(b.variants, qp)
+		}
+	}
+	for key, until := range b.penalised {
+		if now.After(until) {
+			delete(b.penalised, key)
+			b.clearKeyRate(key)
+		}
+	}
+}
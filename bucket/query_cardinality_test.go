@@ -0,0 +1,161 @@
+package bucket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+const testKey = "192.0.2.1/32"
+
+func newTestQueryCardinality(penaltyCPUs float64) *QueryCardinality {
+	return NewQueryCardinality(config.Config{
+		HashMaxLen:                  1000,
+		QueryCardinalityCPUs:        4,
+		QueryCardinalityPenaltyCPUs: penaltyCPUs,
+		QueryCardinalityThreshold:   3,
+		QueryCardinalityWindowSec:   300,
+		QueryCardinalityNetmask:     32,
+	})
+}
+
+func queryRequest(target string) *http.Request {
+	r := httptest.NewRequest("GET", target, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	return r
+}
+
+func reserve(t *testing.T, b *QueryCardinality, target string, qty float64) (time.Duration, bool) {
+	t.Helper()
+	return b.ReserveN(queryRequest(target), time.Now(), qty)
+}
+
+// limit returns the rate the key's limiter is currently running at, in CPUs.
+func limit(b *QueryCardinality, key string) float64 {
+	b.RLock()
+	defer b.RUnlock()
+	return float64(b.hash[EntryKeyed{key: key}.Hash()].limiter.Limit()) / 1000
+}
+
+func penalised(b *QueryCardinality, key string) bool {
+	b.RLock()
+	defer b.RUnlock()
+	_, ok := b.rates[key]
+	return ok
+}
+
+func TestQueryCardinalityCountsVariants(t *testing.T) {
+	b := newTestQueryCardinality(0.5)
+
+	for _, target := range []string{
+		"/search?q=1",
+		"/search?q=2&page=1",
+		"/search?q=3",
+		// Repeats, reordered parameters, other paths and requests without a query don't count as new variants.
+		"/search?q=1",
+		"/search?page=1&q=2",
+		"/other?q=4",
+		"/search",
+	} {
+		reserve(t, b, target, 0.01)
+	}
+	if penalised(b, testKey) {
+		t.Fatalf("Expected %s not to be penalised at the threshold", testKey)
+	}
+	if got := limit(b, testKey); got != 4 {
+		t.Errorf("Expected the bucket rate of 4 CPUs, got %.2f", got)
+	}
+
+	reserve(t, b, "/search?q=5", 0.01)
+	if !penalised(b, testKey) {
+		t.Fatalf("Expected %s to be penalised over the threshold", testKey)
+	}
+	if got := limit(b, testKey); got != 0.5 {
+		t.Errorf("Expected the penalty rate of 0.5 CPUs, got %.2f", got)
+	}
+
+	// Other clients are not affected.
+	other := queryRequest("/search?q=6")
+	other.RemoteAddr = "192.0.2.2:1234"
+	b.ReserveN(other, time.Now(), 0.01)
+	if penalised(b, "192.0.2.2/32") {
+		t.Errorf("Expected 192.0.2.2/32 not to be penalised")
+	}
+}
+
+func TestQueryCardinalityWindow(t *testing.T) {
+	b := newTestQueryCardinality(0.5)
+
+	for _, target := range []string{"/search?q=1", "/search?q=2", "/search?q=3"} {
+		reserve(t, b, target, 0.01)
+	}
+
+	// Variants that have fallen out of the window are forgotten.
+	b.Lock()
+	b.sweep(time.Now().Add(b.window + time.Second))
+	b.Unlock()
+
+	reserve(t, b, "/search?q=4", 0.01)
+	if penalised(b, testKey) {
+		t.Errorf("Expected %s not to be penalised for variants outside of the window", testKey)
+	}
+}
+
+func TestQueryCardinalityLiftsPenalty(t *testing.T) {
+	b := newTestQueryCardinality(0.5)
+
+	for _, target := range []string{"/search?q=1", "/search?q=2", "/search?q=3", "/search?q=4"} {
+		reserve(t, b, target, 0.01)
+	}
+	if !penalised(b, testKey) {
+		t.Fatalf("Expected %s to be penalised over the threshold", testKey)
+	}
+
+	// The penalty outlasts the sweep within the window.
+	b.Lock()
+	b.sweep(time.Now().Add(b.window / 2))
+	b.Unlock()
+	if !penalised(b, testKey) {
+		t.Fatalf("Expected %s to stay penalised within the window", testKey)
+	}
+
+	b.Lock()
+	b.sweep(time.Now().Add(b.window + time.Second))
+	b.Unlock()
+	if penalised(b, testKey) {
+		t.Errorf("Expected the penalty on %s to be lifted after the window", testKey)
+	}
+	if got := limit(b, testKey); got != 4 {
+		t.Errorf("Expected the bucket rate of 4 CPUs to be restored, got %.2f", got)
+	}
+}
+
+func TestQueryCardinalityZeroPenaltyBlocks(t *testing.T) {
+	for _, test := range []struct {
+		penaltyCPUs float64
+		blocked     bool
+	}{
+		{0, true},
+		{0.5, false},
+	} {
+		b := newTestQueryCardinality(test.penaltyCPUs)
+		for _, target := range []string{"/search?q=1", "/search?q=2", "/search?q=3", "/search?q=4"} {
+			reserve(t, b, target, 0.01)
+		}
+
+		// Spend the rest of the 30s burst, then ask for more.
+		if _, ok := reserve(t, b, "/search?q=1", 29.9); !ok {
+			t.Fatalf("Expected the burst to cover the request at penalty %.2f", test.penaltyCPUs)
+		}
+		delay, ok := reserve(t, b, "/search?q=1", 1)
+		if ok == test.blocked {
+			t.Errorf("Expected blocked %t at penalty %.2f, got ok %t with delay %s", test.blocked, test.penaltyCPUs, ok, delay)
+		}
+		if !test.blocked && delay <= 0 {
+			t.Errorf("Expected a delay at penalty %.2f, got %s", test.penaltyCPUs, delay)
+		}
+	}
+}
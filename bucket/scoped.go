@@ -0,0 +1,26 @@
+package bucket
+
+import (
+	"fmt"
+)
+
+// Scoped wraps a bucket dedicated to a single site, so it can be told apart from the shared one.
+type Scoped struct {
+	Bucketable
+	scope string
+}
+
+func NewScoped(b Bucketable, scope string) *Scoped {
+	return &Scoped{
+		Bucketable: b,
+		scope:      scope,
+	}
+}
+
+func (b *Scoped) String() string {
+	return fmt.Sprintf("%s@%s", b.Bucketable, b.scope)
+}
+
+func (b *Scoped) Scope() string {
+	return b.scope
+}
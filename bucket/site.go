@@ -0,0 +1,72 @@
+package bucket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Site caps the total CPU share of each virtual host, so one site can't starve the others on the same server.
+type Site struct {
+	Keyed
+	conf config.Config
+}
+
+func NewSite(c config.Config) *Site {
+	b := &Site{}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *Site) SetConfig(c config.Config) {
+	b.Lock()
+	b.conf = c
+	b.rate = c.SiteCPUs
+	b.rates = make(map[string]float64)
+	for _, s := range c.Sites {
+		b.rates[siteKey(s.Name)] = s.CPUs
+	}
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *Site) String() string {
+	return "Site"
+}
+
+func (b *Site) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, title := b.site(r)
+	return b.reserveN(key, title, start, qty)
+}
+
+func (b *Site) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	key, _ := b.site(r)
+	return b.settleN(key, start, qty)
+}
+
+func (b *Site) Debt(r *http.Request) time.Duration {
+	key, _ := b.site(r)
+	return b.debt(key)
+}
+
+// Hosts that don't belong to a configured site share a single cap. The Host header is up to the client, so capping
+// them individually would hand out a fresh allowance for every made-up host.
+func (b *Site) site(r *http.Request) (key, title string) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if s, ok := b.conf.SiteFor(r.Host); ok {
+		return siteKey(s.Name), s.Name
+	}
+	return "other", "(other hosts)"
+}
+
+func siteKey(name string) string {
+	return "site:" + name
+}
@@ -0,0 +1,74 @@
+package bucket
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/fingerprint"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// TLSFingerprint classifies by the ClientHello, so crawler fleets that rotate IPs and User-Agents but share
+// a TLS stack are limited together. Plain HTTP requests are not limited by this bucket.
+type TLSFingerprint struct {
+	Keyed
+	fingerprints *fingerprint.TLS
+}
+
+func NewTLSFingerprint(c config.Config, fingerprints *fingerprint.TLS) *TLSFingerprint {
+	b := &TLSFingerprint{
+		fingerprints: fingerprints,
+	}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
+	b.SetConfig(c)
+	go b.ticker()
+	return b
+}
+
+func (b *TLSFingerprint) SetConfig(c config.Config) {
+	b.Lock()
+	b.rate = c.TLSFingerprintCPUs
+	b.truncate(0)
+	b.Unlock()
+
+	b.Bucket.SetConfig(c)
+}
+
+func (b *TLSFingerprint) String() string {
+	return "TLSFingerprint"
+}
+
+func (b *TLSFingerprint) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	if r.TLS == nil {
+		return 0, true
+	}
+	fp, found := b.fingerprints.Lookup(r.RemoteAddr)
+	if !found {
+		return 0, true
+	}
+	return b.reserveN(fp.Hash, fmt.Sprintf("%s (%s)", fp.Hash, fp.Summary), start, qty)
+}
+
+func (b *TLSFingerprint) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	if r.TLS == nil {
+		return 0, true
+	}
+	fp, found := b.fingerprints.Lookup(r.RemoteAddr)
+	if !found {
+		return 0, true
+	}
+	return b.settleN(fp.Hash, start, qty)
+}
+
+func (b *TLSFingerprint) Debt(r *http.Request) time.Duration {
+	if r.TLS == nil {
+		return 0
+	}
+	fp, found := b.fingerprints.Lookup(r.RemoteAddr)
+	if !found {
+		return 0
+	}
+	return b.debt(fp.Hash)
+}
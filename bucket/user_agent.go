@@ -1,26 +1,65 @@
 package bucket
 
 import (
-	"crypto/md5"
-	"fmt"
-	"io"
 	"net/http"
-	"sort"
+	"regexp"
+	"strings"
 	"time"
 
-	"golang.org/x/time/rate"
 	"github.com/mateusz/tempomat/lib/config"
 )
 
+// Built-in families, in the order they are tried. Crawlers come first as they often mimic browsers, and
+// Chromium derivatives come before Chrome, which comes before Safari.
+var userAgentFamilies = []struct {
+	token  string
+	family string
+}{
+	{"googlebot", "Googlebot"},
+	{"bingbot", "Bingbot"},
+	{"yandexbot", "YandexBot"},
+	{"baiduspider", "Baiduspider"},
+	{"ahrefsbot", "AhrefsBot"},
+	{"semrushbot", "SemrushBot"},
+	{"mj12bot", "MJ12bot"},
+	{"petalbot", "PetalBot"},
+	{"gptbot", "GPTBot"},
+	{"facebookexternalhit", "facebookexternalhit"},
+	{"python-requests", "python-requests"},
+	{"python-urllib", "Python-urllib"},
+	{"aiohttp", "aiohttp"},
+	{"scrapy", "Scrapy"},
+	{"go-http-client", "Go-http-client"},
+	{"okhttp", "okhttp"},
+	{"apache-httpclient", "Apache-HttpClient"},
+	{"java", "Java"},
+	{"curl", "curl"},
+	{"wget", "Wget"},
+	{"libwww-perl", "libwww-perl"},
+	{"node-fetch", "node-fetch"},
+	{"axios", "axios"},
+	{"edg/", "Edge"},
+	{"opr/", "Opera"},
+	{"firefox", "Firefox"},
+	{"chrome", "Chrome"},
+	{"safari", "Safari"},
+}
+
+// Version numbers, and random hex IDs that some bots append.
+var userAgentVersions = regexp.MustCompile(`\b\d[\w.\-]*|\b[0-9a-fA-F\-]{16,}\b`)
+var userAgentSpaces = regexp.MustCompile(`\s+`)
+
 type UserAgent struct {
-	Bucket
-	hash map[string]EntryUserAgent
+	Keyed
+	rules         []config.UserAgentRule
+	stripVersions bool
+	families      bool
 }
 
 func NewUserAgent(c config.Config) *UserAgent {
-	b := &UserAgent{
-		hash: make(map[string]EntryUserAgent),
-	}
+	b := &UserAgent{}
+	b.hash = make(map[string]EntryKeyed)
+	b.burst = 120
 	b.SetConfig(c)
 	go b.ticker()
 	return b
@@ -29,6 +68,9 @@ func NewUserAgent(c config.Config) *UserAgent {
 func (b *UserAgent) SetConfig(c config.Config) {
 	b.Lock()
 	b.rate = c.UserAgentCPUs
+	b.rules = c.UserAgentRules
+	b.stripVersions = c.UserAgentStripVersions
+	b.families = c.UserAgentFamilies
 	b.truncate(0)
 	b.Unlock()
 
@@ -39,147 +81,52 @@ func (b *UserAgent) String() string {
 	return "UserAgent"
 }
 
-func (b *UserAgent) Entries() Entries {
-	b.RLock()
-	defer b.RUnlock()
-
-	l := make(Entries, len(b.hash))
-	i := 0
-	for _, v := range b.hash {
-		l[i] = v
-		i++
-	}
-	return l
-}
-
-func (b *UserAgent) entries() Entries {
-	l := make(Entries, len(b.hash))
-	i := 0
-	for _, v := range b.hash {
-		l[i] = v
-		i++
-	}
-	return l
-}
-
 func (b *UserAgent) ReserveN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
 	ua := r.UserAgent()
-
-	b.Lock()
-	defer b.Unlock()
-	entry := EntryUserAgent{
-		userAgent: ua,
-	}
-	key := entry.Hash()
-
-	if _, ok := b.hash[key]; ok {
-		entry = b.hash[key];
-	} else {
-		entry.limiter = rate.NewLimiter(rate.Limit(b.rate * 1000), 120 * 1000)
-		b.hash[key] = entry
+	key := b.normalise(ua)
+	title := ua
+	if key != ua {
+		// The title is set by the first request, so this keeps a raw sample around.
+		title = key + " (" + ua + ")"
 	}
+	return b.reserveN(key, title, start, qty)
+}
 
-	rsv := entry.limiter.ReserveN(start, int(qty * 1000))
-	if rsv.OK() && rsv.Delay()!=rate.InfDuration {
-		ok = true
-		delay = rsv.Delay()
-	} else {
-		ok = false
-		delay = 120 * time.Second
-	}
-
-	var delayRemaining time.Duration
-	elapsed := time.Now().Sub(start)
-	if elapsed<=delay {
-		delayRemaining = delay-elapsed
-	}
-
-	sincePrev := time.Now().Sub(entry.lastUsed)
-	if sincePrev>0 && sincePrev<time.Minute {
-		entry.avgSincePrev -= entry.avgSincePrev/10
-		entry.avgSincePrev += sincePrev/10
-	}
-
-	entry.lastUsed = time.Now()
-	entry.avgWait -= entry.avgWait/10
-	entry.avgWait += delayRemaining /10
-
-	cpuSecsPerSec := qty/float64(entry.avgSincePrev.Seconds())
-	if cpuSecsPerSec<100.0 {
-		entry.avgCpuSecs -= entry.avgCpuSecs / 10
-		entry.avgCpuSecs += cpuSecsPerSec / 10
-	}
-	b.hash[key] = entry
+func (b *UserAgent) SettleN(r *http.Request, start time.Time, qty float64) (delay time.Duration, ok bool) {
+	return b.settleN(b.normalise(r.UserAgent()), start, qty)
+}
 
-	return
+func (b *UserAgent) Debt(r *http.Request) time.Duration {
+	return b.debt(b.normalise(r.UserAgent()))
 }
 
-// Not concurrency safe.
-func (b *UserAgent) truncate(truncatedSize int) {
-	entries := b.entries()
+// normalise applies the configured rules, then the built-in families, then strips versions.
+func (b *UserAgent) normalise(ua string) string {
+	b.RLock()
+	defer b.RUnlock()
 
-	sort.Sort(LastUsedSortEntries(entries))
-	purged := make(Entries, 0, len(entries))
-	for i := 0; i < len(entries); i++ {
-		if time.Now().Sub(entries[i].LastUsed())<60*time.Second {
-			purged = append(purged, entries[i])
+	for _, rule := range b.rules {
+		if rule.Family != "" {
+			if m := rule.Regexp.FindStringSubmatchIndex(ua); m != nil {
+				return string(rule.Regexp.ExpandString(nil, rule.Family, ua, m))
+			}
+			continue
 		}
+		ua = rule.Regexp.ReplaceAllString(ua, rule.Replace)
 	}
 
-	sort.Sort(AvgWaitSortEntries(purged))
-	newHash := make(map[string]EntryUserAgent)
-	for i := 0; i < truncatedSize && i<len(purged); i++ {
-		newHash[purged[i].Hash()] = purged[i].(EntryUserAgent)
+	if b.families {
+		lower := strings.ToLower(ua)
+		for _, f := range userAgentFamilies {
+			if strings.Contains(lower, f.token) {
+				return f.family
+			}
+		}
 	}
 
-	// Note: this will overwrite recently added entries
-	b.hash = newHash
-}
-
-func (b *UserAgent) ticker() {
-	ticker := time.NewTicker(time.Minute)
-	for range ticker.C {
-		b.Lock()
-		b.truncate(b.hashMaxLen)
-		b.Unlock()
+	if b.stripVersions {
+		ua = userAgentVersions.ReplaceAllString(ua, "")
+		ua = strings.TrimSpace(userAgentSpaces.ReplaceAllString(ua, " "))
 	}
-}
-
-type EntryUserAgent struct {
-	userAgent    string
-	lastUsed     time.Time
-	avgWait      time.Duration
-	avgSincePrev time.Duration
-	avgCpuSecs   float64
-	limiter      *rate.Limiter
-}
-
-func (e EntryUserAgent) Hash() string {
-	hasher := md5.New()
-	io.WriteString(hasher, e.userAgent)
-	return fmt.Sprintf("%x", hasher.Sum(nil))
-}
-
-func (e EntryUserAgent) LastUsed() time.Time {
-	return e.lastUsed
-}
-
-func (e EntryUserAgent) AvgWait() time.Duration {
-	return e.avgWait
-}
-
-func (e EntryUserAgent) AvgSincePrev() time.Duration {
-	return e.avgSincePrev
-}
-
-func (e EntryUserAgent) AvgCpuSecs() float64 {
-	return e.avgCpuSecs
-}
-
-func (e EntryUserAgent) String() string {
-	return fmt.Sprintf("%s, used %d ago", e.userAgent, time.Now().Sub(e.lastUsed).Seconds())
-}
-
-func (e EntryUserAgent) Title() string {
-	return e.userAgent
+	return ua
 }
@@ -0,0 +1,191 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Challenger serves an interstitial page setting a signed cookie, and verifies the cookie when the client comes
+// back. Nothing leaves the server: the cookie is an expiry and a random id, bound to the client IP with an HMAC.
+type Challenger struct {
+	key    []byte
+	ttl    time.Duration
+	cookie string
+	mode   string
+	// Puzzle seeds already redeemed, and a ring of the same seeds in the order they were redeemed.
+	spent      map[string]bool
+	spentOrder []string
+	spentNext  int
+	spentLock  sync.Mutex
+	sync.RWMutex
+}
+
+func NewChallenger(c config.Config) *Challenger {
+	ch := &Challenger{
+		spent:      make(map[string]bool),
+		spentOrder: make([]string, maxSpent),
+	}
+	ch.SetConfig(c)
+	return ch
+}
+
+func (ch *Challenger) SetConfig(c config.Config) {
+	ch.Lock()
+	defer ch.Unlock()
+	ch.key = []byte(c.ChallengeKey)
+	ch.ttl = time.Duration(c.ChallengeTTLSec*1000) * time.Millisecond
+	ch.cookie = c.ChallengeCookie
+	ch.mode = c.ChallengeMode
+}
+
+// Verify checks the challenge cookie, returning the id it was issued with.
+func (ch *Challenger) Verify(r *http.Request, ip string) (string, bool) {
+	ch.RLock()
+	defer ch.RUnlock()
+
+	c, err := r.Cookie(ch.cookie)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(c.Value, "-")
+	if len(parts) != 3 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(ch.sign(parts[0], parts[1], ip))) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// Serve responds with the interstitial. In "js" mode the cookie is only assembled by the script, so clients
+// that merely store cookies don't pass. In "refresh" mode it's set by the header and the page reloads itself.
+func (ch *Challenger) Serve(w http.ResponseWriter, r *http.Request, ip string) error {
+	ch.RLock()
+	defer ch.RUnlock()
+
+	_, value, err := ch.issue(ip)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+	if ch.mode == "refresh" {
+		ch.setCookie(w, r, value)
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	secure := ""
+	if r.TLS != nil {
+		secure = "; Secure"
+	}
+	return page.Execute(w, struct {
+		Refresh bool
+		Cookie  string
+		Value   string
+		Attrs   string
+	}{
+		Refresh: ch.mode == "refresh",
+		Cookie:  ch.cookie,
+		Value:   reverse(value),
+		Attrs:   fmt.Sprintf("; path=/; max-age=%d; SameSite=Lax%s", int(ch.ttl.Seconds()), secure),
+	})
+}
+
+// Pass issues the cookie to a client that has proven itself some other way, setting it on the response.
+func (ch *Challenger) Pass(w http.ResponseWriter, r *http.Request, ip string) (string, error) {
+	ch.RLock()
+	defer ch.RUnlock()
+
+	id, value, err := ch.issue(ip)
+	if err != nil {
+		return "", err
+	}
+	ch.setCookie(w, r, value)
+	return id, nil
+}
+
+// issue creates a new cookie value for the IP. Not concurrency safe.
+func (ch *Challenger) issue(ip string) (id, value string, err error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ch.ttl).Unix(), 10)
+	id = hex.EncodeToString(nonce)
+	return id, fmt.Sprintf("%s-%s-%s", expires, id, ch.sign(expires, id, ip)), nil
+}
+
+// Not concurrency safe.
+func (ch *Challenger) setCookie(w http.ResponseWriter, r *http.Request, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ch.cookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ch.ttl.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sign binds the cookie to the client IP, so it can't be handed around a crawler fleet. Not concurrency safe.
+func (ch *Challenger) sign(expires, id, ip string) string {
+	mac := hmac.New(sha256.New, ch.key)
+	fmt.Fprintf(mac, "%s|%s|%s", expires, id, ip)
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+var page = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+{{if .Refresh}}<meta http-equiv="refresh" content="1">
+{{end}}<title>Just a moment</title>
+</head>
+<body>
+<p>The site is busy. This page will reload shortly.</p>
+{{if not .Refresh}}<noscript><p>Please enable JavaScript to continue.</p></noscript>
+<script>document.cookie = {{.Cookie}} + "=" + {{.Value}}.split("").reverse().join("") + {{.Attrs}}; location.reload();</script>
+{{end}}</body>
+</html>
+`))
+
+type passedKey struct{}
+
+// WithPassed tags the request context with the id of the passed challenge.
+func WithPassed(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, passedKey{}, id)
+}
+
+// PassedFromContext returns the id of the passed challenge, or "" if the client hasn't passed one.
+func PassedFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(passedKey{}).(string)
+	return id
+}
@@ -0,0 +1,141 @@
+package challenge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+const testIP = "192.0.2.1"
+
+func newTestChallenger(mode string) *Challenger {
+	return NewChallenger(config.Config{
+		ChallengeKey:    "test-key",
+		ChallengeTTLSec: 3600,
+		ChallengeCookie: "tempomat_challenge",
+		ChallengeMode:   mode,
+	})
+}
+
+func cookieRequest(ch *Challenger, value string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: ch.cookie, Value: value})
+	return r
+}
+
+// signed builds a cookie value expiring at the given time.
+func signed(ch *Challenger, expires time.Time, id, ip string) string {
+	e := strconv.FormatInt(expires.Unix(), 10)
+	return e + "-" + id + "-" + ch.sign(e, id, ip)
+}
+
+func TestVerify(t *testing.T) {
+	ch := newTestChallenger("js")
+
+	value := signed(ch, time.Now().Add(time.Hour), "0000000000000001", testIP)
+	id, ok := ch.Verify(cookieRequest(ch, value), testIP)
+	if id != "0000000000000001" || !ok {
+		t.Errorf("Expected cookie '%s' to be accepted, got '%s' %t", value, id, ok)
+	}
+
+	if _, ok := ch.Verify(httptest.NewRequest("GET", "/", nil), testIP); ok {
+		t.Errorf("Expected a request without the cookie to be rejected")
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	ch := newTestChallenger("js")
+
+	value := signed(ch, time.Now().Add(-time.Minute), "0000000000000002", testIP)
+	if _, ok := ch.Verify(cookieRequest(ch, value), testIP); ok {
+		t.Errorf("Expected expired cookie '%s' to be rejected", value)
+	}
+
+	// Extending the expiry breaks the signature.
+	parts := strings.Split(value, "-")
+	parts[0] = strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	extended := strings.Join(parts, "-")
+	if _, ok := ch.Verify(cookieRequest(ch, extended), testIP); ok {
+		t.Errorf("Expected extended cookie '%s' to be rejected", extended)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	ch := newTestChallenger("js")
+
+	value := signed(ch, time.Now().Add(time.Hour), "0000000000000003", testIP)
+	tampered := value[:len(value)-1] + "x"
+	if _, ok := ch.Verify(cookieRequest(ch, tampered), testIP); ok {
+		t.Errorf("Expected cookie '%s' with a tampered signature to be rejected", tampered)
+	}
+
+	other := newTestChallenger("js")
+	other.SetConfig(config.Config{ChallengeKey: "other-key", ChallengeTTLSec: 3600, ChallengeCookie: "tempomat_challenge"})
+	if _, ok := other.Verify(cookieRequest(other, value), testIP); ok {
+		t.Errorf("Expected cookie '%s' signed with another key to be rejected", value)
+	}
+}
+
+func TestVerifyOtherIP(t *testing.T) {
+	ch := newTestChallenger("js")
+
+	value := signed(ch, time.Now().Add(time.Hour), "0000000000000004", testIP)
+	if _, ok := ch.Verify(cookieRequest(ch, value), "192.0.2.2"); ok {
+		t.Errorf("Expected cookie '%s' replayed from another IP to be rejected", value)
+	}
+}
+
+var scriptValue = regexp.MustCompile(`\+ "([^"]+)"\.split\(""\)\.reverse\(\)`)
+
+func TestServeJS(t *testing.T) {
+	ch := newTestChallenger("js")
+
+	w := httptest.NewRecorder()
+	if err := ch.Serve(w, httptest.NewRequest("GET", "/", nil), testIP); err != nil {
+		t.Fatalf("Serve failed: %s", err)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected no cookie header in js mode")
+	}
+
+	m := scriptValue.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("Expected the page to set the cookie, got '%s'", w.Body.String())
+	}
+	value := reverse(m[1])
+	if _, ok := ch.Verify(cookieRequest(ch, value), testIP); !ok {
+		t.Errorf("Expected cookie '%s' assembled by the script to be accepted", value)
+	}
+}
+
+func TestServeRefresh(t *testing.T) {
+	ch := newTestChallenger("refresh")
+
+	w := httptest.NewRecorder()
+	if err := ch.Serve(w, httptest.NewRequest("GET", "/", nil), testIP); err != nil {
+		t.Fatalf("Serve failed: %s", err)
+	}
+	if !strings.Contains(w.Body.String(), `http-equiv="refresh"`) {
+		t.Errorf("Expected the page to reload itself, got '%s'", w.Body.String())
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != ch.cookie {
+		t.Fatalf("Expected the '%s' cookie to be set, got %v", ch.cookie, cookies)
+	}
+	if _, ok := ch.Verify(cookieRequest(ch, cookies[0].Value), testIP); !ok {
+		t.Errorf("Expected cookie '%s' set by the header to be accepted", cookies[0].Value)
+	}
+	if _, ok := ch.Verify(cookieRequest(ch, cookies[0].Value), "192.0.2.2"); ok {
+		t.Errorf("Expected cookie '%s' to be rejected from another IP", cookies[0].Value)
+	}
+}
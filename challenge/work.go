@@ -0,0 +1,205 @@
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a puzzle can be worked on.
+const workTTL = 5 * time.Minute
+
+// How many redeemed seeds are remembered.
+const maxSpent = 10000
+
+// ServeWork responds with a hashcash-style puzzle: the client has to find a counter such that the SHA-256 of
+// the seed and the counter starts with the given number of zero bits. The solution is sent back in a cookie,
+// which VerifyWork checks. Every extra bit doubles the expected work.
+func (ch *Challenger) ServeWork(w http.ResponseWriter, r *http.Request, ip string, difficulty int) error {
+	ch.RLock()
+	defer ch.RUnlock()
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	expires := strconv.FormatInt(time.Now().Add(workTTL).Unix(), 10)
+	id := hex.EncodeToString(nonce)
+	bitsStr := strconv.Itoa(difficulty)
+	seed := fmt.Sprintf("%s-%s-%s-%s", expires, id, bitsStr, ch.signWork(expires, id, bitsStr, ip))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	secure := ""
+	if r.TLS != nil {
+		secure = "; Secure"
+	}
+	return workPage.Execute(w, struct {
+		Cookie string
+		Seed   string
+		Bits   int
+		Attrs  string
+	}{
+		Cookie: ch.workCookie(),
+		Seed:   seed,
+		Bits:   difficulty,
+		Attrs:  fmt.Sprintf("; path=/; max-age=%d; SameSite=Lax%s", int(workTTL.Seconds()), secure),
+	})
+}
+
+// VerifyWork checks the puzzle solution sent back by the client. Each solution is only accepted once, so that it
+// can't be replayed to keep getting new challenge cookies.
+func (ch *Challenger) VerifyWork(r *http.Request, ip string) bool {
+	ch.RLock()
+	defer ch.RUnlock()
+
+	c, err := r.Cookie(ch.workCookie())
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(c.Value, "-")
+	if len(parts) != 5 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return false
+	}
+	if !hmac.Equal([]byte(parts[3]), []byte(ch.signWork(parts[0], parts[1], parts[2], ip))) {
+		return false
+	}
+	if zeroBits(sha256.Sum256([]byte(c.Value))) < difficulty {
+		return false
+	}
+	return ch.spend(parts[1])
+}
+
+// spend redeems the seed, unless it has been redeemed already. Once full, the oldest seed makes room for the new
+// one, so replaying a seed takes solving maxSpent other puzzles first.
+func (ch *Challenger) spend(id string) bool {
+	ch.spentLock.Lock()
+	defer ch.spentLock.Unlock()
+
+	if ch.spent[id] {
+		return false
+	}
+	if oldest := ch.spentOrder[ch.spentNext]; oldest != "" {
+		delete(ch.spent, oldest)
+	}
+	ch.spentOrder[ch.spentNext] = id
+	ch.spentNext = (ch.spentNext + 1) % maxSpent
+	ch.spent[id] = true
+	return true
+}
+
+// Not concurrency safe.
+func (ch *Challenger) workCookie() string {
+	return ch.cookie + "_work"
+}
+
+// The seed is signed separately from the cookie, so one can't be passed off as the other. Not concurrency safe.
+func (ch *Challenger) signWork(expires, id, difficulty, ip string) string {
+	mac := hmac.New(sha256.New, ch.key)
+	fmt.Fprintf(mac, "work|%s|%s|%s|%s", expires, id, difficulty, ip)
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func zeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// The page carries its own SHA-256, as WebCrypto is only available over HTTPS. The work is done in batches,
+// so the page stays responsive.
+var workPage = template.Must(template.New("work").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Just a moment</title>
+</head>
+<body>
+<p>The site is under heavy load. Your browser is doing a quick check, and this page will reload shortly.</p>
+<noscript><p>Please enable JavaScript to continue.</p></noscript>
+<script>
+function sha256(s) {
+	var K = [0x428a2f98,0x71374491,0xb5c0fbcf,0xe9b5dba5,0x3956c25b,0x59f111f1,0x923f82a4,0xab1c5ed5,
+		0xd807aa98,0x12835b01,0x243185be,0x550c7dc3,0x72be5d74,0x80deb1fe,0x9bdc06a7,0xc19bf174,
+		0xe49b69c1,0xefbe4786,0x0fc19dc6,0x240ca1cc,0x2de92c6f,0x4a7484aa,0x5cb0a9dc,0x76f988da,
+		0x983e5152,0xa831c66d,0xb00327c8,0xbf597fc7,0xc6e00bf3,0xd5a79147,0x06ca6351,0x14292967,
+		0x27b70a85,0x2e1b2138,0x4d2c6dfc,0x53380d13,0x650a7354,0x766a0abb,0x81c2c92e,0x92722c85,
+		0xa2bfe8a1,0xa81a664b,0xc24b8b70,0xc76c51a3,0xd192e819,0xd6990624,0xf40e3585,0x106aa070,
+		0x19a4c116,0x1e376c08,0x2748774c,0x34b0bcb5,0x391c0cb3,0x4ed8aa4a,0x5b9cca4f,0x682e6ff3,
+		0x748f82ee,0x78a5636f,0x84c87814,0x8cc70208,0x90befffa,0xa4506ceb,0xbef9a3f7,0xc67178f2];
+	var H = [0x6a09e667,0xbb67ae85,0x3c6ef372,0xa54ff53a,0x510e527f,0x9b05688c,0x1f83d9ab,0x5be0cd19];
+	var m = [], l = s.length * 8, i, j;
+	for (i = 0; i < s.length; i++) m[i >> 2] |= (s.charCodeAt(i) & 255) << (24 - (i % 4) * 8);
+	m[l >> 5] |= 0x80 << (24 - l % 32);
+	m[((l + 64 >> 9) << 4) + 15] = l;
+	for (i = 0; i < m.length; i += 16) {
+		var a = H.slice(0), w = [];
+		for (j = 0; j < 64; j++) {
+			if (j < 16) {
+				w[j] = m[i + j] | 0;
+			} else {
+				var x = w[j - 15], y = w[j - 2];
+				w[j] = (((x >>> 7 | x << 25) ^ (x >>> 18 | x << 14) ^ (x >>> 3)) + w[j - 16] +
+					((y >>> 17 | y << 15) ^ (y >>> 19 | y << 13) ^ (y >>> 10)) + w[j - 7]) | 0;
+			}
+			var e = a[4], b = a[0];
+			var t1 = (a[7] + ((e >>> 6 | e << 26) ^ (e >>> 11 | e << 21) ^ (e >>> 25 | e << 7)) +
+				((e & a[5]) ^ (~e & a[6])) + K[j] + w[j]) | 0;
+			var t2 = (((b >>> 2 | b << 30) ^ (b >>> 13 | b << 19) ^ (b >>> 22 | b << 10)) +
+				((b & a[1]) ^ (b & a[2]) ^ (a[1] & a[2]))) | 0;
+			a.unshift((t1 + t2) | 0);
+			a[4] = (a[4] + t1) | 0;
+			a.pop();
+		}
+		for (j = 0; j < 8; j++) H[j] = (H[j] + a[j]) | 0;
+	}
+	return H;
+}
+function zeroBits(h) {
+	for (var i = 0, n = 0; i < h.length; i++) {
+		if (h[i] != 0) return n + Math.clz32(h[i]);
+		n += 32;
+	}
+	return n;
+}
+var seed = {{.Seed}}, bits = {{.Bits}}, counter = 0;
+function work() {
+	for (var end = counter + 20000; counter < end; counter++) {
+		var solution = seed + "-" + counter;
+		if (zeroBits(sha256(solution)) >= bits) {
+			document.cookie = {{.Cookie}} + "=" + solution + {{.Attrs}};
+			location.reload();
+			return;
+		}
+	}
+	setTimeout(work, 0);
+}
+work();
+</script>
+</body>
+</html>
+`))
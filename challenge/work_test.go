@@ -0,0 +1,138 @@
+package challenge
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seed signs a puzzle the same way ServeWork does.
+func seed(ch *Challenger, id string, difficulty int, ip string) string {
+	expires := strconv.FormatInt(time.Now().Add(workTTL).Unix(), 10)
+	bits := strconv.Itoa(difficulty)
+	return fmt.Sprintf("%s-%s-%s-%s", expires, id, bits, ch.signWork(expires, id, bits, ip))
+}
+
+// solve brute-forces the first counter from the given one for which the solution has at least min and below max
+// zero bits.
+func solve(s string, from, min, max int) (string, int) {
+	for counter := from; ; counter++ {
+		solution := fmt.Sprintf("%s-%d", s, counter)
+		n := zeroBits(sha256.Sum256([]byte(solution)))
+		if n >= min && n < max {
+			return solution, counter
+		}
+	}
+}
+
+func workRequest(ch *Challenger, solution string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: ch.workCookie(), Value: solution})
+	return r
+}
+
+func TestZeroBits(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   int
+	}{
+		{[]byte{0xff}, 0},
+		{[]byte{0x80}, 0},
+		{[]byte{0x40}, 1},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0xff}, 8},
+		{[]byte{0x00, 0x0f}, 12},
+		{[]byte{0x00, 0x00, 0x00, 0x01}, 31},
+	}
+	for _, test := range tests {
+		var hash [sha256.Size]byte
+		for i := range hash {
+			hash[i] = 0xff
+		}
+		copy(hash[:], test.prefix)
+		if got := zeroBits(hash); got != test.want {
+			t.Errorf("Expected %d zero bits for %x, got %d", test.want, test.prefix, got)
+		}
+	}
+
+	var zero [sha256.Size]byte
+	if got := zeroBits(zero); got != sha256.Size*8 {
+		t.Errorf("Expected %d zero bits for an all-zero hash, got %d", sha256.Size*8, got)
+	}
+}
+
+func TestVerifyWork(t *testing.T) {
+	ch := newTestChallenger("work")
+
+	solution, _ := solve(seed(ch, "0000000000000001", 8, testIP), 0, 8, 257)
+	if !ch.VerifyWork(workRequest(ch, solution), testIP) {
+		t.Errorf("Expected solution '%s' to be accepted", solution)
+	}
+}
+
+func TestVerifyWorkTooLittleDifficulty(t *testing.T) {
+	ch := newTestChallenger("work")
+
+	solution, _ := solve(seed(ch, "0000000000000002", 8, testIP), 0, 0, 8)
+	if ch.VerifyWork(workRequest(ch, solution), testIP) {
+		t.Errorf("Expected solution '%s' with too few zero bits to be rejected", solution)
+	}
+
+	// Lowering the difficulty in the seed breaks its signature.
+	parts := strings.Split(seed(ch, "0000000000000003", 8, testIP), "-")
+	parts[2] = "1"
+	lowered, _ := solve(strings.Join(parts, "-"), 0, 1, 257)
+	if ch.VerifyWork(workRequest(ch, lowered), testIP) {
+		t.Errorf("Expected solution '%s' with lowered difficulty to be rejected", lowered)
+	}
+}
+
+func TestVerifyWorkReplay(t *testing.T) {
+	ch := newTestChallenger("work")
+
+	s := seed(ch, "0000000000000004", 4, testIP)
+	solution, counter := solve(s, 0, 4, 257)
+	if !ch.VerifyWork(workRequest(ch, solution), testIP) {
+		t.Fatalf("Expected solution '%s' to be accepted", solution)
+	}
+	if ch.VerifyWork(workRequest(ch, solution), testIP) {
+		t.Errorf("Expected replayed solution '%s' to be rejected", solution)
+	}
+
+	// Another counter for the same seed is a replay too.
+	other, _ := solve(s, counter+1, 4, 257)
+	if ch.VerifyWork(workRequest(ch, other), testIP) {
+		t.Errorf("Expected another solution '%s' for a spent seed to be rejected", other)
+	}
+}
+
+func TestSpendEvictsOldest(t *testing.T) {
+	ch := newTestChallenger("work")
+
+	for i := 0; i < maxSpent; i++ {
+		if !ch.spend(fmt.Sprintf("seed%d", i)) {
+			t.Fatalf("Expected seed%d to be spent", i)
+		}
+	}
+	if ch.spend("seed0") {
+		t.Errorf("Expected seed0 to still be remembered")
+	}
+
+	if !ch.spend("new") {
+		t.Errorf("Expected a new seed to be spent once the ring is full")
+	}
+	if len(ch.spent) != maxSpent {
+		t.Errorf("Expected %d spent seeds, got %d", maxSpent, len(ch.spent))
+	}
+	if !ch.spend("seed0") {
+		t.Errorf("Expected the oldest seed to have been evicted")
+	}
+	if ch.spend("seed2") {
+		t.Errorf("Expected seed2 to still be remembered")
+	}
+}
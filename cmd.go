@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
@@ -16,17 +16,58 @@ import (
 
 	"log"
 	"sync"
+	"sync/atomic"
 
+	"github.com/mateusz/tempomat/adaptive"
 	"github.com/mateusz/tempomat/api"
 	"github.com/mateusz/tempomat/bucket"
+	"github.com/mateusz/tempomat/challenge"
+	"github.com/mateusz/tempomat/cost"
+	"github.com/mateusz/tempomat/crawler"
+	"github.com/mateusz/tempomat/emergency"
+	"github.com/mateusz/tempomat/fingerprint"
+	"github.com/mateusz/tempomat/ipdb"
 	"github.com/mateusz/tempomat/lib/config"
+	"github.com/mateusz/tempomat/predict"
+	"github.com/mateusz/tempomat/queue"
 )
 
 var conf config.Config
 var confMutex sync.RWMutex
 
+// All buckets, for stats and the API.
 var buckets []bucket.Bucketable
 
+// Buckets for sites that don't have their own, buckets dedicated to sites (by site name), and buckets
+// applied to every request.
+var defaultBuckets []bucket.Bucketable
+var siteBuckets map[string][]bucket.Bucketable
+var globalBuckets []bucket.Bucketable
+var costModels cost.Stack
+var systemStats *cost.StatsSampler
+var predictor *predict.Predictor
+var sites *vhosts
+var certificates *certStore
+var tlsFingerprints *fingerprint.TLS
+var headerOrders = fingerprint.NewHeaderOrder()
+var asnDatabase *ipdb.ASN
+var geoDatabase *ipdb.Country
+var crawlerVerifier *crawler.Verifier
+var tarpits *tarpit
+var emergencyMonitor *emergency.Monitor
+var emergencyQueue *queue.Queue
+var adaptiveController *adaptive.Controller
+
+// Number of requests currently held, updated atomically.
+var heldCount int64
+
+// Verified crawlers bypass the per-client buckets, and only go through their own lane and the site cap.
+var crawlerBuckets []bucket.Bucketable
+var challenger *challenge.Challenger
+
+// Clients that passed the challenge bypass the per-client buckets too, going through their own lane instead.
+var challengeBuckets []bucket.Bucketable
+
 var stdoutLog *log.Logger
 var stderrLog *log.Logger
 
@@ -67,10 +108,209 @@ func init() {
 		conf.Print(stdoutLog)
 	}
 
-	buckets = append(buckets, bucket.NewSlash32(conf, 32))
-	buckets = append(buckets, bucket.NewSlash32(conf, 24))
-	buckets = append(buckets, bucket.NewSlash32(conf, 16))
-	buckets = append(buckets, bucket.NewUserAgent(conf))
+	costModels, err = cost.NewStack(conf)
+	if err != nil {
+		stderrLog.Printf("%s\n", err)
+		os.Exit(1)
+	}
+	systemStats = cost.NewStatsSampler(conf.TotalCPUs)
+	predictor = predict.NewPredictor(conf)
+	// Changes to the cap need a restart.
+	tarpits = newTarpit(conf.TarpitMaxConns)
+
+	sites, err = newVhosts(conf, &timingTransport{http.DefaultTransport})
+	if err != nil {
+		stderrLog.Printf("%s\n", err)
+		os.Exit(1)
+	}
+
+	certificates = newCertStore()
+	if conf.TLSListenPort != 0 {
+		if err := certificates.Load(conf); err != nil {
+			stderrLog.Printf("%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	defaultBuckets = newBucketSet(conf, "")
+	buckets = append(buckets, defaultBuckets...)
+
+	// Changes to isolated sites need a restart.
+	siteBuckets = make(map[string][]bucket.Bucketable)
+	for _, s := range conf.Sites {
+		if s.IsolateBuckets {
+			siteBuckets[s.Name] = newBucketSet(conf, s.Name)
+			buckets = append(buckets, siteBuckets[s.Name]...)
+		}
+	}
+	scaleSiteBuckets(conf)
+
+	// TLS stacks are shared across sites.
+	tlsFingerprints = fingerprint.NewTLS()
+	if conf.TLSListenPort != 0 {
+		globalBuckets = append(globalBuckets, bucket.NewTLSFingerprint(conf, tlsFingerprints))
+	}
+
+	// The database is reloaded on SIGHUP, but enabling it needs a restart.
+	if conf.ASNDatabase != "" {
+		asnDatabase, err = ipdb.NewASN(conf.ASNDatabase)
+		if err != nil {
+			stderrLog.Printf("%s\n", err)
+			os.Exit(1)
+		}
+		globalBuckets = append(globalBuckets, bucket.NewASN(conf, asnDatabase))
+	}
+
+	if conf.GeoDatabase != "" {
+		geoDatabase, err = ipdb.NewCountry(conf.GeoDatabase)
+		if err != nil {
+			stderrLog.Printf("%s\n", err)
+			os.Exit(1)
+		}
+		globalBuckets = append(globalBuckets, bucket.NewGeo(conf, geoDatabase))
+	}
+
+	// Changes to the list of composites need a restart.
+	for _, c := range conf.Composites {
+		globalBuckets = append(globalBuckets, bucket.NewComposite(conf, c.Name))
+	}
+
+	// Changes to the list of classifiers need a restart.
+	for _, c := range conf.Classifiers {
+		globalBuckets = append(globalBuckets, bucket.NewClassifier(conf, c.Name))
+	}
+
+	siteBucket := bucket.NewSite(conf)
+	globalBuckets = append(globalBuckets, siteBucket)
+	buckets = append(buckets, globalBuckets...)
+
+	// Enabling verification needs a restart.
+	if conf.VerifyCrawlers {
+		ttl := time.Duration(conf.CrawlerCacheTTLSec * float64(time.Second))
+		crawlerVerifier = crawler.NewVerifier(net.DefaultResolver, crawler.Known, ttl)
+		crawlerBucket := bucket.NewVerifiedCrawler(conf)
+		crawlerBuckets = []bucket.Bucketable{crawlerBucket, siteBucket}
+		buckets = append(buckets, crawlerBucket)
+	}
+
+	// Enabling challenges needs a restart.
+	if conf.ChallengeDebtSec > 0 || conf.ProofOfWorkLoadFactor > 0 {
+		challenger = challenge.NewChallenger(conf)
+		challengeBucket := bucket.NewChallengePassed(conf)
+		challengeBuckets = []bucket.Bucketable{challengeBucket, siteBucket}
+		buckets = append(buckets, challengeBucket)
+	}
+
+	// Enabling emergency mode needs a restart.
+	if conf.EmergencyEnterFactor > 0 {
+		emergencyQueue = queue.NewQueue(conf.EmergencyConcurrency)
+		emergencyMonitor = emergency.NewMonitor(conf, emergencyChanged)
+	}
+
+	// Enabling the adaptive controller needs a restart.
+	if conf.AdaptiveSignal != "" {
+		adaptiveController = adaptive.NewController(conf, adaptiveChanged)
+	}
+}
+
+// adaptiveChanged applies the scale the controller settled on to all buckets.
+func adaptiveChanged(scale float64) {
+	confMutex.RLock()
+	debug := conf.Debug
+	confMutex.RUnlock()
+
+	if debug {
+		stdoutLog.Printf("Adaptive scale: %.2f", scale)
+	}
+	for _, b := range buckets {
+		b.SetScale("adaptive", scale)
+	}
+}
+
+// emergencyChanged scales all buckets down while the server is over capacity.
+func emergencyChanged(active bool, usage float64) {
+	confMutex.RLock()
+	scale := conf.EmergencyScale
+	totalCPUs := conf.TotalCPUs
+	confMutex.RUnlock()
+
+	if active {
+		stdoutLog.Printf("Emergency mode on: %.2fcpus used of %.2f", usage, totalCPUs)
+	} else {
+		stdoutLog.Printf("Emergency mode off: %.2fcpus used of %.2f", usage, totalCPUs)
+		scale = 1.0
+	}
+	for _, b := range buckets {
+		b.SetScale("emergency", scale)
+	}
+	sendMetric("emergency", fmt.Sprintf("%d", emergencyLevel()))
+}
+
+func emergencyLevel() int {
+	if emergencyMonitor != nil && emergencyMonitor.Active() {
+		return 1
+	}
+	return 0
+}
+
+func newBucketSet(c config.Config, site string) []bucket.Bucketable {
+	set := []bucket.Bucketable{
+		bucket.NewSlash32(c, 32),
+		bucket.NewSlash32(c, 24),
+		bucket.NewSlash32(c, 16),
+		bucket.NewUserAgent(c),
+		bucket.NewHeaderFingerprint(c),
+		bucket.NewQueryCardinality(c),
+	}
+	if site != "" {
+		for i, b := range set {
+			set[i] = bucket.NewScoped(b, site)
+		}
+	}
+	return set
+}
+
+// scaleSiteBuckets limits the buckets dedicated to a site to the site's share of the CPUs.
+func scaleSiteBuckets(c config.Config) {
+	for _, s := range c.Sites {
+		for _, b := range siteBuckets[s.Name] {
+			b.SetScale("site", s.CPUs/c.TotalCPUs)
+		}
+	}
+}
+
+// bucketsFor returns the buckets applicable to the request.
+func bucketsFor(r *http.Request) []bucket.Bucketable {
+	if p, ok := priorityFor(r); ok && p.SkipBuckets {
+		return nil
+	}
+	if crawler.VerifiedFromContext(r.Context()) != "" {
+		return crawlerBuckets
+	}
+	if challenge.PassedFromContext(r.Context()) != "" {
+		return challengeBuckets
+	}
+
+	confMutex.RLock()
+	site, ok := conf.SiteFor(r.Host)
+	confMutex.RUnlock()
+
+	set := defaultBuckets
+	if ok {
+		if siteSet, found := siteBuckets[site.Name]; found {
+			set = siteSet
+		}
+	}
+
+	l := make([]bucket.Bucketable, 0, len(set)+len(globalBuckets))
+	l = append(l, set...)
+	return append(l, globalBuckets...)
+}
+
+func priorityFor(r *http.Request) (config.Priority, bool) {
+	confMutex.RLock()
+	defer confMutex.RUnlock()
+	return conf.PriorityFor(r.Method, r.URL.Path)
 }
 
 func statsLogger() {
@@ -82,7 +322,44 @@ func statsLogger() {
 					stdoutLog.Printf("%s,'%s',%.2f,%.2f", b, e.Title(), e.AvgWait().Seconds(), e.AvgSincePrev().Seconds())
 				}
 			}
-			sendMetric(b.String(), fmt.Sprintf("%d", CountOverThreshold(b)))
+			sendMetric(strings.Replace(b.String(), ".", "_", -1), fmt.Sprintf("%d", CountOverThreshold(b)))
+		}
+		sendMetric("tarpit", fmt.Sprintf("%d", tarpits.Count()))
+		sendMetric("held", fmt.Sprintf("%d", atomic.LoadInt64(&heldCount)))
+		if emergencyMonitor != nil {
+			sendMetric("emergency", fmt.Sprintf("%d", emergencyLevel()))
+			sendMetric("usage", fmt.Sprintf("%.2f", emergencyMonitor.Usage()))
+			sendMetric("queued", fmt.Sprintf("%d", emergencyQueue.Waiting()))
+		}
+		if adaptiveController != nil {
+			sendMetric("adaptive", fmt.Sprintf("%.2f", adaptiveController.Scale()))
+		}
+	}
+}
+
+// capacityWatcher scales the buckets down when part of the backend pool is out of rotation, as the pool
+// can no longer provide the configured CPUs.
+func capacityWatcher() {
+	ticker := time.NewTicker(time.Second)
+	for range ticker.C {
+		capacity := sites.Capacity("")
+		for _, b := range defaultBuckets {
+			b.SetScale("pool", capacity)
+		}
+		for _, b := range globalBuckets {
+			b.SetScale("pool", capacity)
+		}
+		for _, b := range crawlerBuckets {
+			b.SetScale("pool", capacity)
+		}
+		for _, b := range challengeBuckets {
+			b.SetScale("pool", capacity)
+		}
+		for site, set := range siteBuckets {
+			capacity := sites.Capacity(site)
+			for _, b := range set {
+				b.SetScale("pool", capacity)
+			}
 		}
 	}
 }
@@ -116,64 +393,310 @@ func sendMetric(metric string, value string) {
 func middleware(proxy http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		proxy.ServeHTTP(w, r)
-		// TODO I'm not sure if this expresses the time from beginning till the end. Reqs
-		// that take 3s to complete (because of load) register as 0.7 here...
-		reqTime := time.Since(start)
+		// Before anything looks at the fingerprint, as the recorded header order can only be taken once.
+		r = fingerprint.TakeHeaderNames(r)
 
-		// Cost is expressed in the amount of compute seconds consumed.
-		// It's scaled down by load average to certain degree if the CPU is 100% saturated to take CPU contention into account.
-		cost := float64(reqTime) / float64(time.Second)
+		confMutex.RLock()
+		admission := conf.Admission
+		trustedProxiesMap := conf.TrustedProxiesMap
+		challengeDebt := time.Duration(conf.ChallengeDebtSec*1000) * time.Millisecond
+		workLoadFactor := conf.ProofOfWorkLoadFactor
+		workMinBits := conf.ProofOfWorkMinBits
+		workMaxBits := conf.ProofOfWorkMaxBits
+		workDebtPerBit := conf.ProofOfWorkDebtSecPerBit
+		queueTimeout := time.Duration(conf.EmergencyQueueTimeoutSec*1000) * time.Millisecond
+		tarpitDebt := time.Duration(conf.TarpitDebtSec*1000) * time.Millisecond
+		tarpitBytesPerSec := conf.TarpitBytesPerSec
+		tarpitMax := time.Duration(conf.TarpitMaxSec*1000) * time.Millisecond
+		tarpitBufferBytes := conf.TarpitBufferBytes
+		confMutex.RUnlock()
 
-		var maxDelay time.Duration
-		header := 200
-		for _, b := range buckets {
-			// TODO
-			// be very very careful of not reading the request.Body unless copying it before.
-			// The Body is a io.ReadClose so if you read it, it will be empty (closed) for other calls to it,
-			// @see https://medium.com/@xoen/golang-read-from-an-io-readwriter-without-loosing-its-content-2c6911805361
-			// I'm not sure how this works with the reverseProxy functionality
-			// wouldn't it be great if we could mark stuff as immutable.
-			bucketDelay, ok := b.ReserveN(r, start, cost)
-			if !ok {
-				header = 503
+		// Priority classes are charged a fraction of the cost, and may jump the emergency queue.
+		costFactor := 1.0
+		queuePriority := 0
+		exempt := false
+		if p, ok := priorityFor(r); ok {
+			costFactor = p.CostFactorValue
+			queuePriority = p.QueuePriority
+			exempt = p.SkipBuckets
+		}
+
+		ip := bucket.ClientIP(r, trustedProxiesMap)
+		if crawlerVerifier != nil {
+			if name, ok := crawlerVerifier.Verify(r.Context(), ip, r.UserAgent()); ok {
+				r = r.WithContext(crawler.WithVerified(r.Context(), name))
+			}
+		}
+
+		// Clients deep in debt are asked to prove they run a browser, and those who do get their own lane. Only
+		// idempotent requests are challenged, as the page reloads itself. When the machine is overloaded, every
+		// client has to prove itself by solving a puzzle, which gets harder the deeper the client is in debt.
+		if challenger != nil && !exempt && crawler.VerifiedFromContext(r.Context()) == "" {
+			idempotent := r.Method == http.MethodGet || r.Method == http.MethodHead
+			if id, ok := challenger.Verify(r, ip); ok {
+				r = r.WithContext(challenge.WithPassed(r.Context(), id))
+			} else if challenger.VerifyWork(r, ip) {
+				id, err := challenger.Pass(w, r, ip)
+				if err != nil {
+					stderrLog.Printf("Unable to issue challenge cookie: %s\n", err)
+					w.WriteHeader(503)
+					return
+				}
+				r = r.WithContext(challenge.WithPassed(r.Context(), id))
+			} else if stats := systemStats.Stats(); workLoadFactor > 0 && stats.Load1 >= workLoadFactor*stats.CPUCount {
+				if !idempotent {
+					w.WriteHeader(503)
+					return
+				}
+				difficulty := workMinBits
+				if workDebtPerBit > 0 {
+					difficulty += int(debtFor(r).Seconds() / workDebtPerBit)
+				}
+				if difficulty > workMaxBits {
+					difficulty = workMaxBits
+				}
+				if err := challenger.ServeWork(w, r, ip, difficulty); err != nil {
+					stderrLog.Printf("Unable to serve proof of work: %s\n", err)
+				}
+				return
+			} else if challengeDebt > 0 && idempotent && debtFor(r) > challengeDebt {
+				if err := challenger.Serve(w, r, ip); err != nil {
+					stderrLog.Printf("Unable to serve challenge: %s\n", err)
+				}
+				return
+			}
+		}
+
+		// In admission mode we reserve the expected cost up front and hold the caller before the request reaches
+		// the backend. The difference is settled once the actual cost is known.
+		var predicted float64
+		if admission {
+			if expected, ok := predictor.Predict(r); ok {
+				expected *= costFactor
+				delay, ok := reserve(r, start, expected, false)
+				if !ok {
+					w.WriteHeader(503)
+					return
+				}
+				if !holdCaller(r.Context(), start, delay) {
+					// The request never reaches the backend, so give the reservation back.
+					reserve(r, start, -expected, true)
+					w.WriteHeader(503)
+					return
+				}
+				predicted = expected
+			}
+		}
+
+		// In emergency mode, clients we know nothing good about queue up for a limited number of backend slots,
+		// unless the request belongs to a class that skips the buckets.
+		// The slot is given back as soon as the backend response has been read, without waiting for it to reach
+		// the client. Failing that, once the proxy is done, or on panic, as ReverseProxy aborts the handler when
+		// the client goes away.
+		releaseSlot := func() {}
+		if emergencyLevel() > 0 && !exempt && crawler.VerifiedFromContext(r.Context()) == "" && challenge.PassedFromContext(r.Context()) == "" {
+			ctx, cancel := context.WithTimeout(r.Context(), queueTimeout)
+			err := emergencyQueue.Acquire(ctx, queuePriority)
+			cancel()
+			if err != nil {
+				if predicted > 0 {
+					reserve(r, start, -predicted, true)
+				}
+				w.WriteHeader(503)
+				return
 			}
-			if bucketDelay > maxDelay {
-				maxDelay = bucketDelay
+			var once sync.Once
+			releaseSlot = func() { once.Do(emergencyQueue.Release) }
+			defer releaseSlot()
+			r = r.WithContext(withBackendDone(r.Context(), releaseSlot))
+		}
+
+		// Persistent offenders get the response trickled instead of being held, as long as there are free slots.
+		// The response is buffered first, so the backend isn't kept waiting on the trickle. Upgraded connections
+		// can't be buffered.
+		var tarpitted *tarpitBuffer
+		out := w
+		if tarpitDebt > 0 && r.Header.Get("Upgrade") == "" && debtFor(r) > tarpitDebt && tarpits.Acquire() {
+			defer tarpits.Release()
+			tarpitted = newTarpitBuffer(w, tarpitBufferBytes)
+			out = tarpitted
+		}
+
+		// Clients in debt would be held once the response is ready, but past the cap they can't be. Rather than
+		// letting them through unthrottled, they are turned away before reaching the backend.
+		if tarpitted == nil && heldFull() && debtFor(r) > 0 {
+			if predicted > 0 {
+				reserve(r, start, -predicted, true)
+			}
+			w.WriteHeader(503)
+			return
+		}
+
+		timings := &cost.Timings{Start: time.Now()}
+		sw := newStatusWriter(out, timings)
+		r = r.WithContext(cost.WithTimings(r.Context(), timings))
+		// The body is only wrapped, not read, so it still reaches the backend.
+		var upload *uploadBody
+		if r.Body != nil && r.Body != http.NoBody {
+			upload = &uploadBody{ReadCloser: r.Body}
+			r.Body = upload
+		}
+		proxy.ServeHTTP(sw, r)
+		releaseSlot()
+		// This includes streaming the body to the client. Use the "backend" cost model to only
+		// charge for the time until the backend produced the response headers.
+		timings.End = time.Now()
+		if upload != nil {
+			timings.Upload = upload.Waited()
+		}
+		reqTime := timings.End.Sub(timings.Start)
+
+		// Cost is expressed in the amount of compute seconds consumed, as estimated by the configured cost models.
+		confMutex.RLock()
+		models := costModels
+		confMutex.RUnlock()
+		reqCost := models.Cost(cost.Sample{
+			Request: r,
+			Status:  sw.status,
+			Header:  sw.Header(),
+			Start:   timings.Start,
+			Wall:    reqTime,
+			Timings: *timings,
+			Stats:   systemStats.Stats(),
+		})
+		predictor.Observe(r, reqCost)
+		if emergencyMonitor != nil {
+			emergencyMonitor.Observe(reqCost)
+		}
+		if adaptiveController != nil {
+			if latency, ok := timings.Backend(); ok {
+				adaptiveController.Observe(latency)
 			}
 		}
 
-		if header != 200 {
-			w.WriteHeader(header)
+		// The monitors above see the full cost, as it's what the backend actually spent.
+		charged := reqCost * costFactor
+		var maxDelay time.Duration
+		var ok bool
+		if predicted > 0 {
+			maxDelay, ok = reserve(r, start, charged-predicted, true)
+		} else {
+			maxDelay, ok = reserve(r, start, charged, false)
+		}
+
+		// The trickle stands in for the hold. Responses too large to buffer have already been sent, and are held
+		// as usual.
+		if tarpitted != nil && tarpitted.Buffered() {
+			tarpitted.Trickle(r.Context(), tarpitBytesPerSec, tarpitMax)
+			return
+		}
+		if !ok {
+			w.WriteHeader(503)
+		}
+		if !holdCaller(r.Context(), start, maxDelay) {
+			// Drop the connection rather than hand over a response that should have been delayed. Anything
+			// still buffered is not sent.
+			panic(http.ErrAbortHandler)
 		}
-		holdCaller(start, maxDelay)
 	})
 }
 
-func holdCaller(start time.Time, delay time.Duration) {
+// reserve charges qty CPU-seconds to all buckets, or with settle, corrects an earlier reservation by qty.
+func reserve(r *http.Request, start time.Time, qty float64, settle bool) (maxDelay time.Duration, ok bool) {
+	ok = true
+	for _, b := range bucketsFor(r) {
+		// TODO
+		// be very very careful of not reading the request.Body unless copying it before.
+		// The Body is a io.ReadClose so if you read it, it will be empty (closed) for other calls to it,
+		// @see https://medium.com/@xoen/golang-read-from-an-io-readwriter-without-loosing-its-content-2c6911805361
+		// I'm not sure how this works with the reverseProxy functionality
+		// wouldn't it be great if we could mark stuff as immutable.
+		var bucketDelay time.Duration
+		var bucketOk bool
+		if settle {
+			bucketDelay, bucketOk = b.SettleN(r, start, qty)
+		} else {
+			bucketDelay, bucketOk = b.ReserveN(r, start, qty)
+		}
+		if !bucketOk {
+			ok = false
+		}
+		if bucketDelay > maxDelay {
+			maxDelay = bucketDelay
+		}
+	}
+	return
+}
+
+// heldFull tells whether maxHeldRequests are already being held.
+func heldFull() bool {
+	confMutex.RLock()
+	maxHeld := int64(conf.MaxHeldRequests)
+	confMutex.RUnlock()
+
+	return maxHeld > 0 && atomic.LoadInt64(&heldCount) >= maxHeld
+}
+
+// holdCaller delays the caller until delay has passed since start. It returns false without holding the full delay
+// if the client goes away, or if maxHeldRequests are already being held.
+func holdCaller(ctx context.Context, start time.Time, delay time.Duration) bool {
 	elapsed := time.Now().Sub(start)
 	if elapsed >= delay {
-		return
+		return true
+	}
+
+	confMutex.RLock()
+	maxHeld := int64(conf.MaxHeldRequests)
+	confMutex.RUnlock()
+
+	defer atomic.AddInt64(&heldCount, -1)
+	if held := atomic.AddInt64(&heldCount, 1); maxHeld > 0 && held > maxHeld {
+		return false
 	}
 
-	time.Sleep(delay - elapsed)
+	timer := time.NewTimer(delay - elapsed)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func listen() {
 	confMutex.RLock()
-	backend := conf.Backend
 	listenPort := conf.ListenPort
+	tlsListenPort := conf.TLSListenPort
 	confMutex.RUnlock()
 
-	// beware that url is colliding with the imported package url
-	addr, err := url.Parse(backend)
+	handler := middleware(sites)
+
+	var err error
+	proxyListener, err = inheritedListener(proxyListenerFd, fmt.Sprintf(":%d", listenPort))
 	if err != nil {
+		stderrLog.Printf("Unable to set up listener: %s\n", err)
+		os.Exit(1)
+	}
+	if tlsListenPort != 0 {
+		if err := listenTLS(handler, tlsListenPort); err != nil {
+			stderrLog.Printf("Unable to set up TLS listener: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	os.Unsetenv(inheritEnv)
+	proxyServer = &http.Server{
+		Handler:     handler,
+		ConnContext: headerOrders.ConnContext,
+	}
+	go shutdownHandler()
+	signalReady()
+
+	if err := proxyServer.Serve(headerOrders.Listener(proxyListener)); err != http.ErrServerClosed {
 		stderrLog.Printf("%s\n", err)
 		os.Exit(1)
 	}
-	proxy := httputil.NewSingleHostReverseProxy(addr)
-	handler := middleware(proxy)
-	http.ListenAndServe(fmt.Sprintf(":%d", listenPort), handler)
+	<-shutdownDone
 }
 
 func sighupHandler() {
@@ -189,14 +712,38 @@ func sighupHandler() {
 			return
 		}
 
+		newCostModels, err := cost.NewStack(newConfig)
+		if err != nil {
+			stderrLog.Printf("Unale to reload config: %s\n", err)
+			return
+		}
+
 		confMutex.Lock()
 		conf = newConfig
+		costModels = newCostModels
 		confMutex.Unlock()
+		systemStats.SetCPUCount(newConfig.TotalCPUs)
 
 		confMutex.RLock()
 		for _, b := range buckets {
 			b.SetConfig(conf)
 		}
+		predictor.SetConfig(conf)
+		if emergencyMonitor != nil {
+			emergencyMonitor.SetConfig(conf)
+			emergencyQueue.SetSlots(conf.EmergencyConcurrency)
+		}
+		if adaptiveController != nil {
+			adaptiveController.SetConfig(conf)
+		}
+		if challenger != nil {
+			challenger.SetConfig(conf)
+		}
+		scaleSiteBuckets(conf)
+		reloadFiles(conf)
+		if err := sites.SetConfig(conf); err != nil {
+			stderrLog.Printf("Unable to reconfigure backends: %s\n", err)
+		}
 
 		if conf.Debug {
 			conf.Print(stdoutLog)
@@ -205,22 +752,58 @@ func sighupHandler() {
 	}
 }
 
+// sigusr1Handler reloads the files the config points to, without reloading the config itself. Reloading the
+// config resets all buckets, which a routine certificate renewal shouldn't do.
+func sigusr1Handler() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	for range c {
+		stdoutLog.Print("SIGUSR1 received, reloading certificates and databases\n")
+
+		confMutex.RLock()
+		reloadFiles(conf)
+		confMutex.RUnlock()
+	}
+}
+
+func reloadFiles(c config.Config) {
+	if c.TLSListenPort != 0 {
+		if err := certificates.Load(c); err != nil {
+			stderrLog.Printf("Unable to reload certificates: %s\n", err)
+		}
+	}
+	if asnDatabase != nil && c.ASNDatabase != "" {
+		if err := asnDatabase.Load(c.ASNDatabase); err != nil {
+			stderrLog.Printf("%s\n", err)
+		}
+	}
+	if geoDatabase != nil && c.GeoDatabase != "" {
+		if err := geoDatabase.Load(c.GeoDatabase); err != nil {
+			stderrLog.Printf("%s\n", err)
+		}
+	}
+}
+
 func main() {
 	go func() {
 		stdoutLog.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
 
 	go sighupHandler()
+	go sigusr1Handler()
 	go statsLogger()
+	go capacityWatcher()
 
-	rpc.Register(api.NewTempomatAPI(buckets))
+	rpc.Register(api.NewTempomatAPI(buckets, predictor, sites))
 	rpc.HandleHTTP()
-	l, err := net.Listen("tcp", ":29999")
+	var err error
+	rpcListener, err = inheritedListener(rpcListenerFd, ":29999")
 	if err != nil {
 		stderrLog.Printf("Unable to set up RPC listener: %s\n", err)
 		os.Exit(1)
 	}
-	go http.Serve(l, nil)
+	rpcServer = &http.Server{}
+	go rpcServer.Serve(rpcListener)
 
 	listen()
 }
@@ -0,0 +1,23 @@
+package cost
+
+import (
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Backend charges only the time until the backend has produced the response headers, so slow clients
+// don't look like heavy CPU consumers.
+type Backend struct{}
+
+func (m *Backend) SetConfig(c config.Config) {}
+
+func (m *Backend) String() string {
+	return "backend"
+}
+
+func (m *Backend) Cost(s Sample, prev float64) float64 {
+	d, ok := s.Timings.Backend()
+	if !ok {
+		return prev
+	}
+	return d.Seconds()
+}
@@ -0,0 +1,41 @@
+package cost
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Header trusts the backend to report its own processing time in seconds (e.g. "X-Runtime: 0.125").
+// Responses without the header keep the previous cost.
+type Header struct {
+	header string
+	sync.RWMutex
+}
+
+func (m *Header) SetConfig(c config.Config) {
+	m.Lock()
+	defer m.Unlock()
+	m.header = c.CostHeader
+}
+
+func (m *Header) String() string {
+	return "header"
+}
+
+func (m *Header) Cost(s Sample, prev float64) float64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	v := strings.TrimSpace(s.Header.Get(m.header))
+	if v == "" {
+		return prev
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs < 0 {
+		return prev
+	}
+	return secs
+}
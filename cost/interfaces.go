@@ -0,0 +1,28 @@
+package cost
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Sample describes a request that has been served by the backend.
+type Sample struct {
+	Request *http.Request
+	Status  int
+	Header  http.Header
+	Start   time.Time
+	Wall    time.Duration
+	Timings Timings
+	Stats   SystemStats
+}
+
+type CostModel interface {
+	fmt.Stringer
+	// Cost returns the CPU-seconds consumed by the sample. Models are stacked, so prev is the figure
+	// computed by the model preceding this one (the wall time for the first model).
+	Cost(s Sample, prev float64) float64
+	SetConfig(config.Config)
+}
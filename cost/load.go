@@ -0,0 +1,22 @@
+package cost
+
+import (
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Load scales the cost down when the CPU is saturated, to take contention into account: a request that took
+// 3s on a machine with load 3.0 per CPU has only really consumed about 1 CPU-second.
+type Load struct{}
+
+func (m *Load) SetConfig(c config.Config) {}
+
+func (m *Load) String() string {
+	return "load"
+}
+
+func (m *Load) Cost(s Sample, prev float64) float64 {
+	if s.Stats.CPUCount <= 0 || s.Stats.Load1 <= s.Stats.CPUCount {
+		return prev
+	}
+	return prev * s.Stats.CPUCount / s.Stats.Load1
+}
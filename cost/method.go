@@ -0,0 +1,39 @@
+package cost
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Method multiplies the cost by a per-method factor, as form submissions and uploads are usually more
+// expensive to process than the wall time suggests. Methods without a factor are left as they are.
+type Method struct {
+	factors map[string]float64
+	sync.RWMutex
+}
+
+func (m *Method) SetConfig(c config.Config) {
+	m.Lock()
+	defer m.Unlock()
+	m.factors = c.MethodCostFactorsMap
+}
+
+func (m *Method) String() string {
+	return "method"
+}
+
+func (m *Method) Cost(s Sample, prev float64) float64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	method := s.Request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if f, ok := m.factors[method]; ok {
+		return prev * f
+	}
+	return prev
+}
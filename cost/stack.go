@@ -0,0 +1,63 @@
+package cost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Stack applies cost models in the order they were configured.
+type Stack []CostModel
+
+func NewStack(c config.Config) (Stack, error) {
+	s := make(Stack, 0)
+	for _, name := range strings.Split(c.CostModels, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var m CostModel
+		switch name {
+		case "wall":
+			m = &Wall{}
+		case "backend":
+			m = &Backend{}
+		case "header":
+			m = &Header{}
+		case "load":
+			m = &Load{}
+		case "static":
+			m = &Static{}
+		case "method":
+			m = &Method{}
+		case "upload":
+			m = &Upload{}
+		default:
+			return nil, fmt.Errorf("Unknown cost model '%s'", name)
+		}
+		m.SetConfig(c)
+		s = append(s, m)
+	}
+	return s, nil
+}
+
+func (s Stack) Cost(sample Sample) float64 {
+	cost := sample.Wall.Seconds()
+	for _, m := range s {
+		cost = m.Cost(sample, cost)
+	}
+	if cost < 0 {
+		cost = 0
+	}
+	return cost
+}
+
+func (s Stack) String() string {
+	names := make([]string, len(s))
+	for i, m := range s {
+		names[i] = m.String()
+	}
+	return strings.Join(names, ",")
+}
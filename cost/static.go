@@ -0,0 +1,83 @@
+package cost
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Static discounts responses that look like static assets. We can't tell by the URL (people put PHP files in
+// assets), so we look at the response instead.
+type Static struct {
+	factor       float64
+	contentTypes []string
+	heuristics   bool
+	sync.RWMutex
+}
+
+// Headers set by the backend when it hands the file over to the web server.
+var sendfileHeaders = []string{"X-Sendfile", "X-Accel-Redirect", "X-Lighttpd-Send-File"}
+
+func (m *Static) SetConfig(c config.Config) {
+	m.Lock()
+	defer m.Unlock()
+	m.factor = c.StaticCostFactor
+	m.contentTypes = c.StaticContentTypesList
+	m.heuristics = c.StaticHeuristics
+}
+
+func (m *Static) String() string {
+	return "static"
+}
+
+func (m *Static) Cost(s Sample, prev float64) float64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.isStatic(s) {
+		return prev * m.factor
+	}
+	return prev
+}
+
+// Not concurrency safe.
+func (m *Static) isStatic(s Sample) bool {
+	for _, h := range sendfileHeaders {
+		if s.Header.Get(h) != "" {
+			return true
+		}
+	}
+
+	// Sessions are a sure sign the application has been booted.
+	if s.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	ct := strings.ToLower(s.Header.Get("Content-Type"))
+	for _, prefix := range m.contentTypes {
+		if ct != "" && strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+
+	if !m.heuristics {
+		return false
+	}
+
+	if s.Status == http.StatusNotModified {
+		return true
+	}
+
+	// Files served by the web server come with a known length, a modification date and caching headers, while
+	// dynamic output is usually chunked and uncacheable.
+	if s.Status != http.StatusOK || s.Header.Get("Content-Length") == "" || s.Header.Get("Last-Modified") == "" {
+		return false
+	}
+	cc := strings.ToLower(s.Header.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") || strings.Contains(cc, "no-cache") || strings.Contains(cc, "private") {
+		return false
+	}
+	return strings.Contains(cc, "max-age") || strings.Contains(cc, "public") || s.Header.Get("Expires") != ""
+}
@@ -0,0 +1,56 @@
+package cost
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/load"
+)
+
+type SystemStats struct {
+	Load1    float64
+	CPUCount float64
+}
+
+// StatsSampler periodically samples system stats, so requests don't need to hit /proc.
+type StatsSampler struct {
+	stats SystemStats
+	sync.RWMutex
+}
+
+func NewStatsSampler(cpuCount float64) *StatsSampler {
+	s := &StatsSampler{}
+	s.SetCPUCount(cpuCount)
+	s.sample()
+	go s.ticker()
+	return s
+}
+
+func (s *StatsSampler) SetCPUCount(cpuCount float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.CPUCount = cpuCount
+}
+
+func (s *StatsSampler) Stats() SystemStats {
+	s.RLock()
+	defer s.RUnlock()
+	return s.stats
+}
+
+func (s *StatsSampler) sample() {
+	avg, err := load.Avg()
+	if err != nil {
+		return
+	}
+	s.Lock()
+	s.stats.Load1 = avg.Load1
+	s.Unlock()
+}
+
+func (s *StatsSampler) ticker() {
+	ticker := time.NewTicker(5 * time.Second)
+	for range ticker.C {
+		s.sample()
+	}
+}
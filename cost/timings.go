@@ -0,0 +1,38 @@
+package cost
+
+import (
+	"context"
+	"time"
+)
+
+// Timings split the request wall time into the phases we can observe from the proxy. Zero values mean the phase
+// has not been reached (e.g. the backend errored before sending headers). Upload is the total time spent waiting
+// for the client to send the request body.
+type Timings struct {
+	Start          time.Time
+	BackendHeaders time.Time
+	BackendDone    time.Time
+	FirstByte      time.Time
+	End            time.Time
+	Upload         time.Duration
+}
+
+// Backend returns the time the backend took to produce the response headers. This excludes streaming the body to
+// the client, which can take arbitrarily long on a slow link.
+func (t *Timings) Backend() (time.Duration, bool) {
+	if t.BackendHeaders.IsZero() {
+		return 0, false
+	}
+	return t.BackendHeaders.Sub(t.Start), true
+}
+
+type timingsKey struct{}
+
+func WithTimings(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, timingsKey{}, t)
+}
+
+func TimingsFromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
@@ -0,0 +1,19 @@
+package cost
+
+import (
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Upload subtracts the time spent waiting for the client to send the request body, so a large upload over a
+// slow link doesn't look like CPU usage.
+type Upload struct{}
+
+func (m *Upload) SetConfig(c config.Config) {}
+
+func (m *Upload) String() string {
+	return "upload"
+}
+
+func (m *Upload) Cost(s Sample, prev float64) float64 {
+	return prev - s.Timings.Upload.Seconds()
+}
@@ -0,0 +1,18 @@
+package cost
+
+import (
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Wall charges the full wall time of the request, regardless of what the previous models computed.
+type Wall struct{}
+
+func (m *Wall) SetConfig(c config.Config) {}
+
+func (m *Wall) String() string {
+	return "wall"
+}
+
+func (m *Wall) Cost(s Sample, prev float64) float64 {
+	return s.Wall.Seconds()
+}
@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver is the subset of net.Resolver used for verification, so it can be replaced by a stub.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Crawler is a search engine crawler identified by a User-Agent token, whose addresses reverse-resolve to
+// one of the domains.
+type Crawler struct {
+	Name    string
+	Token   string
+	Domains []string
+}
+
+// Known crawlers, as documented by the search engines. Googlebot's documentation also lists
+// googleusercontent.com, but any Google Cloud VM can get a name there, so it's left out.
+var Known = []Crawler{
+	{Name: "Googlebot", Token: "googlebot", Domains: []string{".googlebot.com", ".google.com"}},
+	{Name: "Bingbot", Token: "bingbot", Domains: []string{".search.msn.com"}},
+}
+
+// Verifier confirms the crawler claimed by the User-Agent with a reverse DNS lookup of the client IP, followed
+// by a forward lookup of the name which must resolve back to the same IP. Results are cached.
+type Verifier struct {
+	resolver Resolver
+	crawlers []Crawler
+	ttl      time.Duration
+	timeout  time.Duration
+	cache    map[string]result
+	now      func() time.Time
+	sync.Mutex
+}
+
+type result struct {
+	verified bool
+	expires  time.Time
+}
+
+// Expired results are only swept once the cache grows past this size.
+const sweepSize = 10000
+
+// Failures that may well be gone by the next request, such as DNS timeouts, are only cached for this long, so a
+// slow DNS answer doesn't demote a real crawler for the whole TTL.
+const transientTTL = time.Minute
+
+func NewVerifier(resolver Resolver, crawlers []Crawler, ttl time.Duration) *Verifier {
+	return &Verifier{
+		resolver: resolver,
+		crawlers: crawlers,
+		ttl:      ttl,
+		timeout:  2 * time.Second,
+		cache:    make(map[string]result),
+		now:      time.Now,
+	}
+}
+
+// Claimed returns the crawler the User-Agent claims to be.
+func (v *Verifier) Claimed(ua string) (Crawler, bool) {
+	ua = strings.ToLower(ua)
+	for _, c := range v.crawlers {
+		if strings.Contains(ua, c.Token) {
+			return c, true
+		}
+	}
+	return Crawler{}, false
+}
+
+// Verify returns the name of the crawler if the User-Agent claims to be a known crawler and the IP confirms it.
+// Lookup failures count as not verified, so a fake crawler can't get the crawler lane by breaking DNS.
+func (v *Verifier) Verify(ctx context.Context, ip, ua string) (string, bool) {
+	c, ok := v.Claimed(ua)
+	if !ok {
+		return "", false
+	}
+
+	key := c.Name + "|" + ip
+	now := v.now()
+	v.Lock()
+	cached, found := v.cache[key]
+	v.Unlock()
+	if found && now.Before(cached.expires) {
+		return c.Name, cached.verified
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+	verified, transient := v.confirm(ctx, c, ip)
+	// Don't cache failures caused by the client going away.
+	if ctx.Err() == context.Canceled {
+		return c.Name, false
+	}
+	ttl := v.ttl
+	if transient && ttl > transientTTL {
+		ttl = transientTTL
+	}
+
+	v.Lock()
+	if len(v.cache) >= sweepSize {
+		v.sweep(now)
+	}
+	v.cache[key] = result{verified: verified, expires: now.Add(ttl)}
+	v.Unlock()
+
+	return c.Name, verified
+}
+
+// confirm does the lookups. A failure is transient if any of the lookups failed for a reason other than the name
+// not existing.
+func (v *Verifier) confirm(ctx context.Context, c Crawler, ip string) (verified, transient bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, false
+	}
+
+	names, err := v.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		return false, isTransient(ctx, err)
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !hasDomain(name, c.Domains) {
+			continue
+		}
+		hosts, err := v.resolver.LookupHost(ctx, name)
+		if err != nil {
+			transient = transient || isTransient(ctx, err)
+			continue
+		}
+		for _, h := range hosts {
+			if addr.Equal(net.ParseIP(h)) {
+				return true, false
+			}
+		}
+	}
+	return false, transient
+}
+
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	if dnsErr, ok := err.(*net.DNSError); ok {
+		return !dnsErr.IsNotFound
+	}
+	return true
+}
+
+func (v *Verifier) sweep(now time.Time) {
+	for key, r := range v.cache {
+		if now.After(r.expires) {
+			delete(v.cache, key)
+		}
+	}
+}
+
+func hasDomain(name string, domains []string) bool {
+	for _, d := range domains {
+		if strings.HasSuffix(name, d) {
+			return true
+		}
+	}
+	return false
+}
+
+type crawlerKey struct{}
+
+// WithVerified tags the request context with the verified crawler name.
+func WithVerified(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, crawlerKey{}, name)
+}
+
+// VerifiedFromContext returns the verified crawler name, or "" for everyone else.
+func VerifiedFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(crawlerKey{}).(string)
+	return name
+}
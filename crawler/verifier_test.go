@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type stubResolver struct {
+	addrs   map[string][]string
+	hosts   map[string][]string
+	err     error
+	lookups int
+}
+
+func (r *stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	names, ok := r.addrs[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+const googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+func newStubResolver() *stubResolver {
+	return &stubResolver{
+		addrs: map[string][]string{
+			"66.249.66.1": {"crawl-66-249-66-1.googlebot.com."},
+			"203.0.113.5": {"crawler.example.com."},
+			"203.0.113.6": {"crawl-203-0-113-6.googlebot.com."},
+			"34.66.0.1":   {"1.0.66.34.bc.googleusercontent.com."},
+		},
+		hosts: map[string][]string{
+			"1.0.66.34.bc.googleusercontent.com": {"34.66.0.1"},
+			"crawl-66-249-66-1.googlebot.com":    {"66.249.66.1"},
+			"crawl-203-0-113-6.googlebot.com":    {"66.249.66.2"},
+		},
+	}
+}
+
+// clock lets the tests move time forward without sleeping.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestVerifier(r Resolver, ttl time.Duration) (*Verifier, *clock) {
+	v := NewVerifier(r, Known, ttl)
+	c := &clock{t: time.Now()}
+	v.now = c.now
+	return v, c
+}
+
+func TestVerifyCrawler(t *testing.T) {
+	v, _ := newTestVerifier(newStubResolver(), time.Hour)
+
+	name, ok := v.Verify(context.Background(), "66.249.66.1", googlebotUA)
+	if name != "Googlebot" || !ok {
+		t.Errorf("Expected verified Googlebot, got '%s' %t", name, ok)
+	}
+}
+
+func TestVerifyNotClaimed(t *testing.T) {
+	r := newStubResolver()
+	v, _ := newTestVerifier(r, time.Hour)
+
+	name, ok := v.Verify(context.Background(), "66.249.66.1", "curl/7.88.1")
+	if name != "" || ok {
+		t.Errorf("Expected no crawler, got '%s' %t", name, ok)
+	}
+	if r.lookups != 0 {
+		t.Errorf("Expected no lookups, got %d", r.lookups)
+	}
+}
+
+func TestVerifySpoofedPTR(t *testing.T) {
+	v, _ := newTestVerifier(newStubResolver(), time.Hour)
+
+	name, ok := v.Verify(context.Background(), "203.0.113.5", googlebotUA)
+	if name != "Googlebot" || ok {
+		t.Errorf("Expected unverified Googlebot, got '%s' %t", name, ok)
+	}
+}
+
+func TestVerifyForwardMismatch(t *testing.T) {
+	v, _ := newTestVerifier(newStubResolver(), time.Hour)
+
+	if _, ok := v.Verify(context.Background(), "203.0.113.6", googlebotUA); ok {
+		t.Error("Expected a name resolving to another IP not to verify")
+	}
+}
+
+func TestVerifyCloudVM(t *testing.T) {
+	v, _ := newTestVerifier(newStubResolver(), time.Hour)
+
+	// Resolves both ways, but anyone can run a VM on Google Cloud.
+	if _, ok := v.Verify(context.Background(), "34.66.0.1", googlebotUA); ok {
+		t.Error("Expected a Google Cloud VM not to verify as Googlebot")
+	}
+}
+
+func TestVerifyCacheExpiry(t *testing.T) {
+	r := newStubResolver()
+	v, c := newTestVerifier(r, time.Hour)
+
+	v.Verify(context.Background(), "66.249.66.1", googlebotUA)
+	v.Verify(context.Background(), "66.249.66.1", googlebotUA)
+	if r.lookups != 1 {
+		t.Errorf("Expected the result to be cached, got %d lookups", r.lookups)
+	}
+
+	c.t = c.t.Add(time.Hour + time.Second)
+	if _, ok := v.Verify(context.Background(), "66.249.66.1", googlebotUA); !ok {
+		t.Error("Expected verified Googlebot after expiry")
+	}
+	if r.lookups != 2 {
+		t.Errorf("Expected a new lookup after expiry, got %d lookups", r.lookups)
+	}
+}
+
+func TestVerifyTransientFailure(t *testing.T) {
+	r := newStubResolver()
+	r.err = &net.DNSError{Err: "i/o timeout", Name: "66.249.66.1", IsTimeout: true}
+	v, c := newTestVerifier(r, time.Hour)
+
+	if _, ok := v.Verify(context.Background(), "66.249.66.1", googlebotUA); ok {
+		t.Error("Expected a timeout not to verify")
+	}
+
+	// The failure is only cached briefly, so the real crawler is verified once DNS recovers.
+	r.err = nil
+	c.t = c.t.Add(transientTTL + time.Second)
+	if _, ok := v.Verify(context.Background(), "66.249.66.1", googlebotUA); !ok {
+		t.Error("Expected verified Googlebot once DNS recovered")
+	}
+}
+
+func TestVerifyDefinitiveFailureCached(t *testing.T) {
+	r := newStubResolver()
+	v, c := newTestVerifier(r, time.Hour)
+
+	v.Verify(context.Background(), "198.51.100.1", googlebotUA)
+	c.t = c.t.Add(transientTTL + time.Second)
+	v.Verify(context.Background(), "198.51.100.1", googlebotUA)
+	if r.lookups != 1 {
+		t.Errorf("Expected a missing PTR record to be cached for the full TTL, got %d lookups", r.lookups)
+	}
+}
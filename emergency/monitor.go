@@ -0,0 +1,101 @@
+package emergency
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Monitor adds up the cost of all requests and compares it against the CPUs available. When usage stays above
+// the enter threshold for long enough, emergency mode is switched on, and it's only switched off once usage
+// has stayed below the (lower) exit threshold for long enough.
+type Monitor struct {
+	spent     float64
+	usage     float64
+	over      time.Duration
+	under     time.Duration
+	active    bool
+	enterCPUs float64
+	exitCPUs  float64
+	enterFor  time.Duration
+	exitFor   time.Duration
+	onChange  func(active bool, usage float64)
+	sync.RWMutex
+}
+
+const tick = time.Second
+
+func NewMonitor(c config.Config, onChange func(active bool, usage float64)) *Monitor {
+	m := &Monitor{
+		onChange: onChange,
+	}
+	m.SetConfig(c)
+	go m.ticker()
+	return m
+}
+
+func (m *Monitor) SetConfig(c config.Config) {
+	m.Lock()
+	defer m.Unlock()
+	m.enterCPUs = c.EmergencyEnterFactor * c.TotalCPUs
+	m.exitCPUs = c.EmergencyExitFactor * c.TotalCPUs
+	m.enterFor = time.Duration(c.EmergencyEnterSec*1000) * time.Millisecond
+	m.exitFor = time.Duration(c.EmergencyExitSec*1000) * time.Millisecond
+}
+
+// Observe adds the cost of a request, in CPU-seconds.
+func (m *Monitor) Observe(cost float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.spent += cost
+}
+
+func (m *Monitor) Active() bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.active
+}
+
+// Usage returns the smoothed number of CPUs used.
+func (m *Monitor) Usage() float64 {
+	m.RLock()
+	defer m.RUnlock()
+	return m.usage
+}
+
+func (m *Monitor) ticker() {
+	ticker := time.NewTicker(tick)
+	for range ticker.C {
+		m.Lock()
+		// Costs arrive in lumps when requests finish, so smooth them out over several seconds.
+		m.usage -= m.usage / 5
+		m.usage += m.spent / tick.Seconds() / 5
+		m.spent = 0
+
+		changed := false
+		if m.usage > m.enterCPUs {
+			m.over += tick
+			m.under = 0
+		} else if m.usage < m.exitCPUs {
+			m.under += tick
+			m.over = 0
+		} else {
+			m.over = 0
+			m.under = 0
+		}
+		if !m.active && m.over >= m.enterFor {
+			m.active = true
+			changed = true
+		} else if m.active && m.under >= m.exitFor {
+			m.active = false
+			changed = true
+		}
+		active, usage := m.active, m.usage
+		m.Unlock()
+
+		if changed && m.onChange != nil {
+			m.onChange(active, usage)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on what we are willing to buffer per connection. Anything beyond is left to the http server to reject.
+const (
+	maxLineLen     = 8192
+	maxHeaderLines = 100
+	maxPending     = 16
+)
+
+// How long a client gets to complete the TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// HeaderOrder records the header names of HTTP/1.x requests in the order they were sent, which net/http
+// throws away. It only sees plaintext, so TLS has to be terminated by TLSListener. HTTP/2 requests fall back
+// to the sorted header names.
+type HeaderOrder struct{}
+
+func NewHeaderOrder() *HeaderOrder {
+	return &HeaderOrder{}
+}
+
+// Listener wraps the listener so that request heads can be recorded as they are read.
+func (h *HeaderOrder) Listener(l net.Listener) net.Listener {
+	return &headerListener{Listener: l}
+}
+
+// TLSListener terminates TLS in place of http.Server, so that request heads can be recorded once decrypted.
+// Handshakes run in the background, so a slow client doesn't hold up the others. HTTP/2 connections are passed
+// on as is, as net/http needs the *tls.Conn to serve them. The server has to be started with Serve, and its
+// TLSConfig must list "h2" in NextProtos for HTTP/2 to be served.
+func (h *HeaderOrder) TLSListener(l net.Listener, config *tls.Config) net.Listener {
+	tl := &tlsHeaderListener{
+		Listener: l,
+		config:   config,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go tl.accept()
+	return tl
+}
+
+// ConnContext is meant for http.Server, to make the recorder available to the requests of the connection.
+func (h *HeaderOrder) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if hc, ok := c.(*headerConn); ok {
+		return context.WithValue(ctx, headerOrderKey{}, hc)
+	}
+	return ctx
+}
+
+type headerOrderKey struct{}
+type headerNamesKey struct{}
+
+// TakeHeaderNames claims the recorded header order for the request, and keeps it in the request context for
+// HeaderNames. The recorder hands out heads in the order they arrive on the connection, skipping those that don't
+// match the request, as net/http answers some requests such as "OPTIONS *" without calling the handler. This must be
+// called exactly once for every request. On connections from TLSListener, it also fills in r.TLS, which net/http
+// can't do for a wrapped connection.
+func TakeHeaderNames(r *http.Request) *http.Request {
+	hc, ok := r.Context().Value(headerOrderKey{}).(*headerConn)
+	if !ok {
+		return r
+	}
+	if hc.tls != nil && r.TLS == nil {
+		state := hc.tls.ConnectionState()
+		r = r.WithContext(r.Context())
+		r.TLS = &state
+	}
+	names, ok := hc.recorder.next(r.Method, r.RequestURI)
+	if !ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), headerNamesKey{}, names))
+}
+
+// HeaderNames returns the header names of the request in the order sent, or sorted if the order is not known.
+func HeaderNames(r *http.Request) (names []string, ordered bool) {
+	if names, ok := r.Context().Value(headerNamesKey{}).([]string); ok {
+		return names, true
+	}
+
+	names = make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, false
+}
+
+type headerListener struct {
+	net.Listener
+}
+
+func (l *headerListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	return newHeaderConn(c, nil), nil
+}
+
+type tlsHeaderListener struct {
+	net.Listener
+	config    *tls.Config
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *tlsHeaderListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *tlsHeaderListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *tlsHeaderListener) accept() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			// http.Server retries on temporary errors, and gives up on anything else.
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *tlsHeaderListener) handshake(c net.Conn) {
+	tc := tls.Server(c, l.config)
+	tc.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		tc.Close()
+		return
+	}
+	tc.SetDeadline(time.Time{})
+
+	var conn net.Conn = tc
+	if tc.ConnectionState().NegotiatedProtocol != "h2" {
+		conn = newHeaderConn(tc, tc)
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		tc.Close()
+	}
+}
+
+type headerConn struct {
+	net.Conn
+	recorder *recorder
+	tls      *tls.Conn
+}
+
+func newHeaderConn(c net.Conn, tc *tls.Conn) *headerConn {
+	return &headerConn{Conn: c, recorder: &recorder{heads: make(map[int]head)}, tls: tc}
+}
+
+func (c *headerConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.recorder.feed(p[:n])
+	}
+	return n, err
+}
+
+// recorder parses the stream of requests on a single connection. Requests are numbered in the order they
+// arrive, and handlers, which run one at a time per connection, take them in the same order.
+type recorder struct {
+	line          []byte
+	current       head
+	inHead        bool
+	contentLength int64
+	skip          int64
+	disabled      bool
+	parsed        int
+	taken         int
+	heads         map[int]head
+	sync.Mutex
+}
+
+// head is what we keep of a request head: the request line, to match it up with the request, and the header names.
+type head struct {
+	method string
+	target string
+	names  []string
+}
+
+func (r *recorder) next(method, target string) ([]string, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	for r.taken < r.parsed {
+		h, ok := r.heads[r.taken]
+		delete(r.heads, r.taken)
+		r.taken++
+		if ok && h.method == method && h.target == target {
+			return h.names, true
+		}
+	}
+	return nil, false
+}
+
+func (r *recorder) feed(p []byte) {
+	r.Lock()
+	defer r.Unlock()
+
+	for len(p) > 0 && !r.disabled {
+		if r.skip > 0 {
+			n := int64(len(p))
+			if n > r.skip {
+				n = r.skip
+			}
+			r.skip -= n
+			p = p[n:]
+			continue
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.line = append(r.line, p...)
+			if len(r.line) > maxLineLen {
+				r.disabled = true
+			}
+			return
+		}
+		r.line = append(r.line, p[:i]...)
+		p = p[i+1:]
+		r.parseLine(string(bytes.TrimRight(r.line, "\r")))
+		r.line = r.line[:0]
+	}
+}
+
+// Not concurrency safe.
+func (r *recorder) parseLine(line string) {
+	if !r.inHead {
+		// Tolerate empty lines between requests, as net/http does.
+		if line != "" {
+			r.inHead = true
+			r.current = head{names: make([]string, 0)}
+			if fields := strings.Fields(line); len(fields) == 3 {
+				r.current.method = fields[0]
+				r.current.target = fields[1]
+			}
+			r.contentLength = 0
+		}
+		return
+	}
+
+	if line != "" {
+		if len(r.current.names) >= maxHeaderLines {
+			r.disabled = true
+			return
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return
+		}
+		name := line[:colon]
+		value := strings.TrimSpace(line[colon+1:])
+		r.current.names = append(r.current.names, name)
+
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			r.contentLength, _ = strconv.ParseInt(value, 10, 64)
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			// We'd have to parse the chunks to find the next request. Give up on this connection.
+			r.disabled = true
+		}
+		return
+	}
+
+	// End of head. Skip the body to get to the next request.
+	r.inHead = false
+	r.heads[r.parsed] = r.current
+	r.parsed++
+	if len(r.heads) > maxPending {
+		r.disabled = true
+		return
+	}
+	r.skip = r.contentLength
+}
@@ -0,0 +1,120 @@
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TLSFingerprint is a JA3-style summary of the ClientHello. Clients built on the same TLS stack share it,
+// regardless of their IP or User-Agent. As in JA4, the extensions are sorted, since browsers such as Chrome
+// shuffle them on every connection.
+type TLSFingerprint struct {
+	Hash    string
+	Raw     string
+	Summary string
+}
+
+// TLS records the fingerprint of every TLS connection, so it can be looked up by the remote address of requests.
+type TLS struct {
+	byAddr map[string]TLSFingerprint
+	sync.RWMutex
+}
+
+func NewTLS() *TLS {
+	return &TLS{
+		byAddr: make(map[string]TLSFingerprint),
+	}
+}
+
+// GetConfigForClient is meant for tls.Config. It only peeks at the ClientHello, leaving the config as is.
+func (f *TLS) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	fp := NewTLSFingerprint(hello)
+
+	f.Lock()
+	defer f.Unlock()
+	f.byAddr[hello.Conn.RemoteAddr().String()] = fp
+	return nil, nil
+}
+
+// ConnState is meant for http.Server, to forget connections once they are closed.
+func (f *TLS) ConnState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	delete(f.byAddr, c.RemoteAddr().String())
+}
+
+func (f *TLS) Lookup(remoteAddr string) (TLSFingerprint, bool) {
+	f.RLock()
+	defer f.RUnlock()
+	fp, ok := f.byAddr[remoteAddr]
+	return fp, ok
+}
+
+func NewTLSFingerprint(hello *tls.ClientHelloInfo) TLSFingerprint {
+	var version uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGrease(v) && v > version {
+			version = v
+		}
+	}
+
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+	extensions := make([]uint16, len(hello.Extensions))
+	copy(extensions, hello.Extensions)
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i] < extensions[j] })
+
+	// SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+	raw := fmt.Sprintf("%d,%s,%s,%s,%s",
+		version,
+		join(hello.CipherSuites),
+		join(extensions),
+		join(curves),
+		join(points),
+	)
+
+	hasher := md5.New()
+	hasher.Write([]byte(raw))
+
+	return TLSFingerprint{
+		Hash: fmt.Sprintf("%x", hasher.Sum(nil)),
+		Raw:  raw,
+		Summary: fmt.Sprintf("%s, %d ciphers, %d extensions, alpn '%s'",
+			tls.VersionName(version),
+			len(hello.CipherSuites),
+			len(hello.Extensions),
+			strings.Join(hello.SupportedProtos, ","),
+		),
+	}
+}
+
+// GREASE values are picked at random by the client, so they must not be part of the fingerprint.
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func join(values []uint16) string {
+	l := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGrease(v) {
+			l = append(l, fmt.Sprintf("%d", v))
+		}
+	}
+	return strings.Join(l, "-")
+}
@@ -0,0 +1,143 @@
+package ipdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+type ASNRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+func (r ASNRecord) String() string {
+	return fmt.Sprintf("AS%d %s", r.Number, r.Org)
+}
+
+// ASN maps IPs to the autonomous system announcing them, using either a MaxMind GeoLite2-ASN database
+// (.mmdb) or an iptoasn.com TSV file. Everything is offline.
+type ASN struct {
+	mmdb   *maxminddb.Reader
+	ranges []asnRange
+	sync.RWMutex
+}
+
+type asnRange struct {
+	start  net.IP
+	end    net.IP
+	record ASNRecord
+}
+
+func NewASN(path string) (*ASN, error) {
+	db := &ASN{}
+	if err := db.Load(path); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load replaces the database with the contents of the file. On error the previous database is kept.
+func (db *ASN) Load(path string) error {
+	var mmdb *maxminddb.Reader
+	var ranges []asnRange
+	var err error
+
+	if strings.HasSuffix(path, ".mmdb") {
+		mmdb, err = maxminddb.Open(path)
+	} else {
+		ranges, err = loadASNTSV(path)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to load ASN database '%s': %s", path, err)
+	}
+
+	db.Lock()
+	old := db.mmdb
+	db.mmdb = mmdb
+	db.ranges = ranges
+	db.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (db *ASN) Lookup(ip net.IP) (ASNRecord, bool) {
+	db.RLock()
+	defer db.RUnlock()
+
+	if db.mmdb != nil {
+		var record ASNRecord
+		if err := db.mmdb.Lookup(ip, &record); err != nil || record.Number == 0 {
+			return ASNRecord{}, false
+		}
+		return record, true
+	}
+
+	ip = ip.To16()
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].end, ip) >= 0
+	})
+	if i < len(db.ranges) && bytes.Compare(db.ranges[i].start, ip) <= 0 {
+		return db.ranges[i].record, true
+	}
+	return ASNRecord{}, false
+}
+
+// loadASNTSV reads lines of "range_start range_end AS_number country_code AS_description". Ranges with
+// AS number 0 are not routed, and are skipped.
+func loadASNTSV(path string) ([]asnRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranges := make([]asnRange, 0)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		number, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if number == 0 {
+			continue
+		}
+		start := net.ParseIP(fields[0])
+		end := net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("line %d: invalid range", line)
+		}
+
+		ranges = append(ranges, asnRange{
+			start: start.To16(),
+			end:   end.To16(),
+			record: ASNRecord{
+				Number: uint(number),
+				Org:    fields[4],
+			},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].start, ranges[j].start) < 0 })
+	return ranges, nil
+}
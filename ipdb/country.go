@@ -0,0 +1,73 @@
+package ipdb
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+type CountryRecord struct {
+	ISOCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
+}
+
+func (r CountryRecord) String() string {
+	if name, ok := r.Names["en"]; ok {
+		return fmt.Sprintf("%s %s", r.ISOCode, name)
+	}
+	return r.ISOCode
+}
+
+// Country maps IPs to countries using a MaxMind GeoLite2-Country or -City database. Everything is offline.
+type Country struct {
+	mmdb *maxminddb.Reader
+	sync.RWMutex
+}
+
+func NewCountry(path string) (*Country, error) {
+	db := &Country{}
+	if err := db.Load(path); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load replaces the database with the contents of the file. On error the previous database is kept.
+func (db *Country) Load(path string) error {
+	mmdb, err := maxminddb.Open(path)
+	if err != nil {
+		return fmt.Errorf("Unable to load GeoIP database '%s': %s", path, err)
+	}
+
+	db.Lock()
+	old := db.mmdb
+	db.mmdb = mmdb
+	db.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (db *Country) Lookup(ip net.IP) (CountryRecord, bool) {
+	db.RLock()
+	defer db.RUnlock()
+
+	var record struct {
+		Country           CountryRecord `maxminddb:"country"`
+		RegisteredCountry CountryRecord `maxminddb:"registered_country"`
+	}
+	if err := db.mmdb.Lookup(ip, &record); err != nil {
+		return CountryRecord{}, false
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country, true
+	}
+	if record.RegisteredCountry.ISOCode != "" {
+		return record.RegisteredCountry, true
+	}
+	return CountryRecord{}, false
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Classifier describes a bucket keyed on a single request attribute, e.g. an API key header or a tenant
+// cookie. Requests without the attribute are not limited by it.
+type Classifier struct {
+	Name       string    `json:"name"`
+	Share      float64   `json:"share"`
+	Burst      float64   `json:"burst"`
+	HashMaxLen int       `json:"hashMaxLen"`
+	Extractor  Extractor `json:"extractor"`
+	CPUs       float64   `json:"-"`
+}
+
+func (conf *Config) parseClassifiers(cpuCount float64) error {
+	for i := range conf.Classifiers {
+		c := &conf.Classifiers[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("Classifier%d", i)
+		}
+		if c.Burst == 0 {
+			c.Burst = 30
+		}
+		if c.HashMaxLen == 0 {
+			c.HashMaxLen = conf.HashMaxLen
+		}
+		c.CPUs = 1.0 * cpuCount
+		if c.Share != 0 {
+			c.CPUs = c.Share * cpuCount
+		}
+		if err := c.Extractor.parse(); err != nil {
+			return fmt.Errorf("Classifier '%s': %s", c.Name, err)
+		}
+	}
+	return nil
+}
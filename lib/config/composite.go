@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Composite describes a bucket keyed on several request attributes at once, e.g. "this UA from this /24".
+type Composite struct {
+	Name       string      `json:"name"`
+	Share      float64     `json:"share"`
+	Burst      float64     `json:"burst"`
+	Extractors []Extractor `json:"extractors"`
+	CPUs       float64     `json:"-"`
+}
+
+// Extractor pulls a single attribute out of the request. Type is one of "ip" (with Netmask), "header" and
+// "cookie" (with Name), "bearer" (the subject of an Authorization bearer token), or "path" (the normalised path
+// pattern, unless a Pattern is given). The optional regexp Pattern narrows the value down to the first capture
+// group or the whole match. With Hash, only a hash of the value is kept, for API keys and the like. Headers,
+// cookies and bearer tokens often carry credentials, so they are hashed unless Hash is explicitly false.
+type Extractor struct {
+	Type    string         `json:"type"`
+	Name    string         `json:"name"`
+	Netmask int            `json:"netmask"`
+	Pattern string         `json:"pattern"`
+	Hash    *bool          `json:"hash"`
+	Regexp  *regexp.Regexp `json:"-"`
+	Hashed  bool           `json:"-"`
+}
+
+func (conf *Config) parseComposites(cpuCount float64) error {
+	for i := range conf.Composites {
+		c := &conf.Composites[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("Composite%d", i)
+		}
+		if c.Burst == 0 {
+			c.Burst = 30
+		}
+		c.CPUs = 1.0 * cpuCount
+		if c.Share != 0 {
+			c.CPUs = c.Share * cpuCount
+		}
+		if len(c.Extractors) == 0 {
+			return fmt.Errorf("Composite '%s' has no extractors", c.Name)
+		}
+
+		for j := range c.Extractors {
+			if err := c.Extractors[j].parse(); err != nil {
+				return fmt.Errorf("Composite '%s': %s", c.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Extractor) parse() error {
+	switch e.Type {
+	case "ip":
+		if e.Netmask == 0 {
+			e.Netmask = 32
+		}
+		if e.Netmask < 0 || e.Netmask > 32 {
+			return fmt.Errorf("Invalid netmask %d", e.Netmask)
+		}
+	case "header", "cookie":
+		if e.Name == "" {
+			return fmt.Errorf("Extractor of type '%s' needs a name", e.Type)
+		}
+	case "path", "bearer":
+	default:
+		return fmt.Errorf("Unknown extractor type '%s'", e.Type)
+	}
+
+	e.Hashed = e.Type == "header" || e.Type == "cookie" || e.Type == "bearer"
+	if e.Hash != nil {
+		e.Hashed = *e.Hash
+	}
+
+	if e.Pattern != "" {
+		var err error
+		if e.Regexp, err = regexp.Compile(e.Pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
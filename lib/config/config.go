@@ -4,16 +4,26 @@ import (
 	"net/url"
 	"io/ioutil"
 	"encoding/json"
+	"fmt"
 	"strings"
 	"github.com/shirou/gopsutil/cpu"
 	"log"
+	"math"
 )
 
 type Config struct {
 	Debug             bool            `json:"debug"`
 	DelayThresholdSec float64         `json:"delayThresholdSec"`
 	Backend           string          `json:"backend"`
+	Backends          string          `json:"backends"`
+	BackendsList      []string        `json:"-"`
+	Balance           string          `json:"balance"`
+	HealthCheckPath   string          `json:"healthCheckPath"`
+	HealthCheckIntervalSec float64    `json:"healthCheckIntervalSec"`
+	HealthCheckTimeoutSec  float64    `json:"healthCheckTimeoutSec"`
 	ListenPort        int             `json:"listenPort"`
+	TLSListenPort     int             `json:"tlsListenPort"`
+	TLSCertificates   []TLSCertificate `json:"tlsCertificates"`
 	Graphite          string          `json:"graphite"`
 	GraphitePrefix    string          `json:"graphitePrefix"`
 	TrustedProxies    string          `json:"trustedProxies"`
@@ -22,26 +32,151 @@ type Config struct {
 	Slash24Share      float64         `json:"slash24Share"`
 	Slash16Share      float64         `json:"slash16Share"`
 	UserAgentShare    float64         `json:"userAgentShare"`
+	UserAgentRules    []UserAgentRule `json:"userAgentRules"`
+	UserAgentStripVersions bool       `json:"userAgentStripVersions"`
+	UserAgentFamilies bool            `json:"userAgentFamilies"`
+	QueryCardinalityShare float64     `json:"queryCardinalityShare"`
+	QueryCardinalityPenaltyShare float64 `json:"queryCardinalityPenaltyShare"`
+	QueryCardinalityThreshold int     `json:"queryCardinalityThreshold"`
+	QueryCardinalityWindowSec float64 `json:"queryCardinalityWindowSec"`
+	QueryCardinalityNetmask int       `json:"queryCardinalityNetmask"`
+	TLSFingerprintShare float64       `json:"tlsFingerprintShare"`
+	HeaderFingerprintShare float64    `json:"headerFingerprintShare"`
+	ASNShare          float64         `json:"asnShare"`
+	ASNDatabase       string          `json:"asnDatabase"`
+	GeoShare          float64         `json:"geoShare"`
+	GeoCountryShares  map[string]float64 `json:"geoCountryShares"`
+	GeoDatabase       string          `json:"geoDatabase"`
+	VerifyCrawlers    bool            `json:"verifyCrawlers"`
+	CrawlerShare      float64         `json:"crawlerShare"`
+	CrawlerCacheTTLSec float64        `json:"crawlerCacheTTLSec"`
+	HeaderFingerprintValues string    `json:"headerFingerprintValues"`
+	HeaderFingerprintIgnore string    `json:"headerFingerprintIgnore"`
+	HeaderFingerprintValuesList []string `json:"-"`
+	HeaderFingerprintIgnoreList []string `json:"-"`
 	Slash32CPUs       float64         `json:"-"`
 	Slash24CPUs       float64         `json:"-"`
 	Slash16CPUs       float64         `json:"-"`
 	UserAgentCPUs     float64         `json:"-"`
+	TLSFingerprintCPUs float64        `json:"-"`
+	HeaderFingerprintCPUs float64     `json:"-"`
+	ASNCPUs           float64         `json:"-"`
+	GeoCPUs           float64         `json:"-"`
+	GeoCountryCPUs    map[string]float64 `json:"-"`
+	CrawlerCPUs       float64         `json:"-"`
+	QueryCardinalityCPUs float64      `json:"-"`
+	QueryCardinalityPenaltyCPUs float64 `json:"-"`
 	HashMaxLen        int             `json:"hashMaxLen"`
+	CostModels        string          `json:"costModels"`
+	CostHeader        string          `json:"costHeader"`
+	StaticCostFactor  float64         `json:"staticCostFactor"`
+	StaticContentTypes string         `json:"staticContentTypes"`
+	StaticContentTypesList []string   `json:"-"`
+	StaticHeuristics  bool            `json:"staticHeuristics"`
+	MethodCostFactors map[string]float64 `json:"methodCostFactors"`
+	MethodCostFactorsMap map[string]float64 `json:"-"`
+	TotalCPUs         float64         `json:"-"`
+	Admission         bool            `json:"admission"`
+	ShutdownTimeoutSec float64        `json:"shutdownTimeoutSec"`
+	MaxHeldRequests   int             `json:"maxHeldRequests"`
+	ChallengeDebtSec  float64         `json:"challengeDebtSec"`
+	ChallengeKey      string          `json:"challengeKey"`
+	ChallengeTTLSec   float64         `json:"challengeTTLSec"`
+	ChallengeCookie   string          `json:"challengeCookie"`
+	ChallengeMode     string          `json:"challengeMode"`
+	ChallengeShare    float64         `json:"challengeShare"`
+	ChallengeCPUs     float64         `json:"-"`
+	ProofOfWorkLoadFactor float64     `json:"proofOfWorkLoadFactor"`
+	ProofOfWorkMinBits int            `json:"proofOfWorkMinBits"`
+	ProofOfWorkMaxBits int            `json:"proofOfWorkMaxBits"`
+	ProofOfWorkDebtSecPerBit float64  `json:"proofOfWorkDebtSecPerBit"`
+	EmergencyEnterFactor float64      `json:"emergencyEnterFactor"`
+	EmergencyExitFactor float64       `json:"emergencyExitFactor"`
+	EmergencyEnterSec float64         `json:"emergencyEnterSec"`
+	EmergencyExitSec  float64         `json:"emergencyExitSec"`
+	EmergencyScale    float64         `json:"emergencyScale"`
+	EmergencyConcurrency int          `json:"emergencyConcurrency"`
+	EmergencyQueueTimeoutSec float64  `json:"emergencyQueueTimeoutSec"`
+	AdaptiveSignal    string          `json:"adaptiveSignal"`
+	AdaptiveLatencyTargetMs float64   `json:"adaptiveLatencyTargetMs"`
+	AdaptiveLatencyPercentile float64 `json:"adaptiveLatencyPercentile"`
+	AdaptiveIdleTarget float64        `json:"adaptiveIdleTarget"`
+	AdaptiveMinScale  float64         `json:"adaptiveMinScale"`
+	AdaptiveMaxScale  float64         `json:"adaptiveMaxScale"`
+	AdaptiveStep      float64         `json:"adaptiveStep"`
+	AdaptiveIntervalSec float64       `json:"adaptiveIntervalSec"`
+	TarpitDebtSec     float64         `json:"tarpitDebtSec"`
+	TarpitMaxConns    int             `json:"tarpitMaxConns"`
+	TarpitBytesPerSec int             `json:"tarpitBytesPerSec"`
+	TarpitMaxSec      float64         `json:"tarpitMaxSec"`
+	TarpitBufferBytes int             `json:"tarpitBufferBytes"`
+	Sites             []Site          `json:"sites"`
+	SitesMap          map[string]int  `json:"-"`
+	SiteShare         float64         `json:"siteShare"`
+	SiteCPUs          float64         `json:"-"`
+	Composites        []Composite     `json:"composites"`
+	Classifiers       []Classifier    `json:"classifiers"`
+	Priorities        []Priority      `json:"priorities"`
 	GraphiteURL       *url.URL        `json:"-"`
 	TrustedProxiesMap map[string]bool `json:"-"`
 }
 
+type TLSCertificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
 func NewConfig() (Config, error) {
 
 	conf := Config{
 		Debug:              false,
 		DelayThresholdSec:  3,
 		Backend:            "http://localhost:80",
+		Backends:           "",
+		Balance:            "roundrobin",
+		HealthCheckPath:    "",
+		HealthCheckIntervalSec: 5,
+		HealthCheckTimeoutSec:  2,
 		ListenPort:         8888,
 		Graphite:           "",
 		GraphitePrefix:     "",
 		TrustedProxies:     "",
 		HashMaxLen:         1000,
+		ShutdownTimeoutSec: 30,
+		CrawlerCacheTTLSec: 3600,
+		ChallengeTTLSec:    3600,
+		ChallengeCookie:    "tempomat_challenge",
+		ChallengeMode:      "js",
+		ProofOfWorkMinBits: 12,
+		ProofOfWorkMaxBits: 22,
+		ProofOfWorkDebtSecPerBit: 10,
+		EmergencyExitFactor: 0.8,
+		EmergencyEnterSec:  30,
+		EmergencyExitSec:   60,
+		EmergencyScale:     0.5,
+		EmergencyQueueTimeoutSec: 10,
+		AdaptiveLatencyTargetMs: 1000,
+		AdaptiveLatencyPercentile: 95,
+		AdaptiveIdleTarget: 10,
+		AdaptiveMinScale:   0.25,
+		AdaptiveMaxScale:   2,
+		AdaptiveStep:       0.1,
+		AdaptiveIntervalSec: 5,
+		TarpitMaxConns:     100,
+		TarpitBytesPerSec:  64,
+		TarpitMaxSec:       300,
+		TarpitBufferBytes:  1048576,
+		QueryCardinalityPenaltyShare: 0.1,
+		QueryCardinalityThreshold: 50,
+		QueryCardinalityWindowSec: 300,
+		QueryCardinalityNetmask: 32,
+		SiteShare:          1.0,
+		HeaderFingerprintValues: "Accept,Accept-Encoding",
+		HeaderFingerprintIgnore: "User-Agent,Cookie,Referer,Content-Length,Content-Type,If-Modified-Since,If-None-Match,Cache-Control,Pragma,Origin,X-Forwarded-For,X-Forwarded-Proto,X-Real-Ip",
+		CostModels:         "wall",
+		CostHeader:         "X-Runtime",
+		StaticCostFactor:   0.1,
+		StaticContentTypes: "image/,text/css,application/javascript,font/,audio/,video/",
 		GraphiteURL:        nil,
 		TrustedProxiesMap:  make(map[string]bool),
 	}
@@ -65,11 +200,20 @@ func NewConfig() (Config, error) {
 		cpuCount = conf.CPUCount
 	}
 
+	conf.TotalCPUs = cpuCount
+
 	// Defaults
 	conf.Slash32CPUs = 1.0 * cpuCount
 	conf.Slash24CPUs = 1.0 * cpuCount
 	conf.Slash16CPUs = 1.0 * cpuCount
 	conf.UserAgentCPUs = 1.0 * cpuCount
+	conf.TLSFingerprintCPUs = 1.0 * cpuCount
+	conf.HeaderFingerprintCPUs = 1.0 * cpuCount
+	conf.ASNCPUs = 1.0 * cpuCount
+	conf.GeoCPUs = 1.0 * cpuCount
+	conf.CrawlerCPUs = 1.0 * cpuCount
+	conf.QueryCardinalityCPUs = 1.0 * cpuCount
+	conf.ChallengeCPUs = 1.0 * cpuCount
 
 	if conf.Slash32Share !=0 {
 		conf.Slash32CPUs = conf.Slash32Share * cpuCount
@@ -83,22 +227,127 @@ func NewConfig() (Config, error) {
 	if conf.UserAgentShare !=0 {
 		conf.UserAgentCPUs = conf.UserAgentShare * cpuCount
 	}
+	if conf.TLSFingerprintShare != 0 {
+		conf.TLSFingerprintCPUs = conf.TLSFingerprintShare * cpuCount
+	}
+	if conf.HeaderFingerprintShare != 0 {
+		conf.HeaderFingerprintCPUs = conf.HeaderFingerprintShare * cpuCount
+	}
+	if conf.ASNShare != 0 {
+		conf.ASNCPUs = conf.ASNShare * cpuCount
+	}
+	if conf.GeoShare != 0 {
+		conf.GeoCPUs = conf.GeoShare * cpuCount
+	}
+	conf.GeoCountryCPUs = make(map[string]float64)
+	for country, share := range conf.GeoCountryShares {
+		conf.GeoCountryCPUs[strings.ToUpper(country)] = share * cpuCount
+	}
+	if conf.CrawlerShare != 0 {
+		conf.CrawlerCPUs = conf.CrawlerShare * cpuCount
+	}
+	if conf.QueryCardinalityShare != 0 {
+		conf.QueryCardinalityCPUs = conf.QueryCardinalityShare * cpuCount
+	}
+	conf.QueryCardinalityPenaltyCPUs = conf.QueryCardinalityPenaltyShare * cpuCount
+	if conf.ChallengeShare != 0 {
+		conf.ChallengeCPUs = conf.ChallengeShare * cpuCount
+	}
+	if (conf.ChallengeDebtSec > 0 || conf.ProofOfWorkLoadFactor > 0) && conf.ChallengeKey == "" {
+		return Config{}, fmt.Errorf("Configuration failure: 'challengeKey' is required if 'challengeDebtSec' or 'proofOfWorkLoadFactor' is specified")
+	}
+	if conf.EmergencyEnterFactor > 0 && conf.EmergencyExitFactor >= conf.EmergencyEnterFactor {
+		return Config{}, fmt.Errorf("Invalid emergency factors: exit %.2f must be below enter %.2f", conf.EmergencyExitFactor, conf.EmergencyEnterFactor)
+	}
+	if conf.EmergencyConcurrency == 0 {
+		conf.EmergencyConcurrency = int(math.Ceil(cpuCount))
+	}
+	if conf.ProofOfWorkMinBits < 0 || conf.ProofOfWorkMaxBits > 32 || conf.ProofOfWorkMinBits > conf.ProofOfWorkMaxBits {
+		return Config{}, fmt.Errorf("Invalid proof of work difficulty %d-%d bits", conf.ProofOfWorkMinBits, conf.ProofOfWorkMaxBits)
+	}
+	if conf.ChallengeMode != "js" && conf.ChallengeMode != "refresh" {
+		return Config{}, fmt.Errorf("Unknown challenge mode '%s'", conf.ChallengeMode)
+	}
+	if conf.AdaptiveSignal != "" && conf.AdaptiveSignal != "latency" && conf.AdaptiveSignal != "cpu" {
+		return Config{}, fmt.Errorf("Unknown adaptive signal '%s'", conf.AdaptiveSignal)
+	}
+	if conf.AdaptiveMinScale <= 0 || conf.AdaptiveMinScale > conf.AdaptiveMaxScale {
+		return Config{}, fmt.Errorf("Invalid adaptive scale bounds %.2f-%.2f", conf.AdaptiveMinScale, conf.AdaptiveMaxScale)
+	}
+	if conf.AdaptiveLatencyPercentile <= 0 || conf.AdaptiveLatencyPercentile > 100 {
+		return Config{}, fmt.Errorf("Invalid adaptiveLatencyPercentile %.0f", conf.AdaptiveLatencyPercentile)
+	}
+	if conf.AdaptiveIntervalSec <= 0 {
+		return Config{}, fmt.Errorf("Invalid adaptiveIntervalSec %.0f", conf.AdaptiveIntervalSec)
+	}
+	if conf.QueryCardinalityNetmask < 0 || conf.QueryCardinalityNetmask > 32 {
+		return Config{}, fmt.Errorf("Invalid queryCardinalityNetmask %d", conf.QueryCardinalityNetmask)
+	}
 
 	proxies := strings.Split(conf.TrustedProxies, ",")
 	for _, proxy := range proxies {
 		conf.TrustedProxiesMap[proxy] = true
 	}
 
+	// "backends" takes precedence over the single "backend".
+	conf.BackendsList = splitList(conf.Backends)
+	if len(conf.BackendsList) == 0 {
+		conf.BackendsList = []string{conf.Backend}
+	}
+
+	conf.StaticContentTypesList = splitList(strings.ToLower(conf.StaticContentTypes))
+	conf.MethodCostFactorsMap = make(map[string]float64)
+	for method, factor := range conf.MethodCostFactors {
+		conf.MethodCostFactorsMap[strings.ToUpper(method)] = factor
+	}
+	conf.HeaderFingerprintValuesList = splitList(conf.HeaderFingerprintValues)
+	conf.HeaderFingerprintIgnoreList = splitList(conf.HeaderFingerprintIgnore)
+
+	conf.parseSites(cpuCount)
+	if err := conf.parseComposites(cpuCount); err != nil {
+		return Config{}, err
+	}
+	if err := conf.parseClassifiers(cpuCount); err != nil {
+		return Config{}, err
+	}
+	if err := conf.parseUserAgentRules(); err != nil {
+		return Config{}, err
+	}
+	if err := conf.parsePriorities(); err != nil {
+		return Config{}, err
+	}
+
 	return conf, nil
 }
 
+// splitList splits a comma-separated config value, dropping empty items.
+func splitList(s string) []string {
+	l := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
 func (conf *Config) Print(log *log.Logger) {
 	log.Print("GENERAL")
 	log.Printf("Debug mode:         %t", conf.Debug)
-	log.Printf("Backend URI:        %s", conf.Backend)
+	log.Printf("Backend URIs:       %s", strings.Join(conf.BackendsList, ", "))
+	log.Printf("Balance:            %s (e.g. 'roundrobin', 'leastconn')", conf.Balance)
+	log.Printf("Health check path:  '%s' (empty to disable)", conf.HealthCheckPath)
+	log.Printf("Health check every: %.1fs, timeout %.1fs", conf.HealthCheckIntervalSec, conf.HealthCheckTimeoutSec)
 	log.Printf("Local listen port:  %d", conf.ListenPort)
+	log.Printf("TLS listen port:    %d (0 to disable)", conf.TLSListenPort)
+	for _, pair := range conf.TLSCertificates {
+		log.Printf("TLS certificate:    '%s' (key '%s')", pair.Cert, pair.Key)
+	}
 	log.Printf("Trusted proxy ips:  '%s'", conf.TrustedProxies)
 	log.Printf("Maximum hash size:  %d", conf.HashMaxLen)
+	log.Printf("Shutdown timeout:   %.0fs", conf.ShutdownTimeoutSec)
+	log.Printf("Max held requests:  %d (0 for unlimited)", conf.MaxHeldRequests)
 	log.Print("")
 	log.Print("STATS")
 	log.Printf("Graphite server:    '%s' (e.g. 'tcp://localhost:2003')", conf.Graphite)
@@ -111,10 +360,101 @@ func (conf *Config) Print(log *log.Logger) {
 	log.Printf("Slash24 max CPU share:            %d%%", int(conf.Slash24Share *100.0))
 	log.Printf("Slash16 max CPU share:            %d%%", int(conf.Slash16Share *100.0))
 	log.Printf("UserAgent max CPU share:          %d%%", int(conf.UserAgentShare *100.0))
+	for _, rule := range conf.UserAgentRules {
+		log.Printf("UserAgent rule:                   '%s' -> replace '%s', family '%s'", rule.Pattern, rule.Replace, rule.Family)
+	}
+	log.Printf("UserAgent strip versions:         %t", conf.UserAgentStripVersions)
+	log.Printf("UserAgent built-in families:      %t", conf.UserAgentFamilies)
+	log.Printf("TLSFingerprint max CPU share:     %d%%", int(conf.TLSFingerprintShare*100.0))
+	log.Printf("HeaderFingerprint max CPU share:  %d%%", int(conf.HeaderFingerprintShare*100.0))
+	log.Printf("HeaderFingerprint values:         '%s'", conf.HeaderFingerprintValues)
+	log.Printf("HeaderFingerprint ignored:        '%s'", conf.HeaderFingerprintIgnore)
+	log.Printf("ASN max CPU share:                %d%%", int(conf.ASNShare*100.0))
+	log.Printf("ASN database:                     '%s' (.mmdb or iptoasn .tsv)", conf.ASNDatabase)
+	log.Printf("Geo default max CPU share:        %d%%", int(conf.GeoShare*100.0))
+	for country, share := range conf.GeoCountryShares {
+		log.Printf("Geo %s max CPU share:             %d%%", strings.ToUpper(country), int(share*100.0))
+	}
+	log.Printf("Geo database:                     '%s' (.mmdb)", conf.GeoDatabase)
+	log.Printf("Verify crawlers:                  %t", conf.VerifyCrawlers)
+	log.Printf("Crawler max CPU share:            %d%%", int(conf.CrawlerShare*100.0))
+	log.Printf("Crawler DNS cache TTL:            %.0fs", conf.CrawlerCacheTTLSec)
+	log.Printf("QueryCardinality max CPU share:   %d%%", int(conf.QueryCardinalityShare*100.0))
+	log.Printf("QueryCardinality penalty share:   %d%%", int(conf.QueryCardinalityPenaltyShare*100.0))
+	log.Printf("QueryCardinality threshold:       %d variants per path in %.0fs (0 to disable)", conf.QueryCardinalityThreshold, conf.QueryCardinalityWindowSec)
+	log.Printf("QueryCardinality netmask:         /%d", conf.QueryCardinalityNetmask)
+	log.Print("")
+	log.Print("COST")
+	log.Printf("Cost models:        '%s' (e.g. 'backend,upload,method,static')", conf.CostModels)
+	log.Printf("Cost header:        '%s'", conf.CostHeader)
+	log.Printf("Static cost factor: %.2f", conf.StaticCostFactor)
+	log.Printf("Static types:       '%s'", conf.StaticContentTypes)
+	log.Printf("Static heuristics:  %t", conf.StaticHeuristics)
+	for method, factor := range conf.MethodCostFactorsMap {
+		log.Printf("Method cost factor: %s %.2f", method, factor)
+	}
+	log.Print("")
+	log.Print("SITES")
+	log.Printf("Default site max CPU share:       %d%%", int(conf.SiteShare*100.0))
+	for _, site := range conf.Sites {
+		log.Printf("Site '%s': hosts '%s', backends '%s', share %d%%, isolated buckets %t", site.Name, site.Hosts, site.Backends, int(site.Share*100.0), site.IsolateBuckets)
+	}
+	log.Print("")
+	log.Print("COMPOSITES")
+	for _, c := range conf.Composites {
+		log.Printf("Composite '%s': share %d%%, burst %.0fs, %d extractors", c.Name, int(c.Share*100.0), c.Burst, len(c.Extractors))
+	}
+	log.Print("")
+	log.Print("CLASSIFIERS")
+	for _, c := range conf.Classifiers {
+		log.Printf("Classifier '%s': %s '%s', share %d%%, burst %.0fs, hash size %d, hashed %t", c.Name, c.Extractor.Type, c.Extractor.Name, int(c.Share*100.0), c.Burst, c.HashMaxLen, c.Extractor.Hashed)
+	}
+	log.Print("")
+	log.Print("PRIORITIES")
+	for _, p := range conf.Priorities {
+		log.Printf("Priority '%s': paths '%s', methods '%s', skip buckets %t, cost factor %.2f, queue priority %d", p.Name, p.Paths, p.Methods, p.SkipBuckets, p.CostFactorValue, p.QueuePriority)
+	}
+	log.Print("")
+	log.Print("ADMISSION")
+	log.Printf("Admission mode:     %t", conf.Admission)
+	log.Print("")
+	log.Print("CHALLENGE")
+	log.Printf("Challenge debt thr: %.0fs (0 to disable)", conf.ChallengeDebtSec)
+	log.Printf("Challenge key set:  %t", conf.ChallengeKey != "")
+	log.Printf("Challenge cookie:   '%s', valid for %.0fs", conf.ChallengeCookie, conf.ChallengeTTLSec)
+	log.Printf("Challenge mode:     %s (e.g. 'js', 'refresh')", conf.ChallengeMode)
+	log.Printf("Challenge max CPU share:          %d%%", int(conf.ChallengeShare*100.0))
+	log.Printf("Proof of work load: %.2f per CPU (0 to disable)", conf.ProofOfWorkLoadFactor)
+	log.Printf("Proof of work bits: %d-%d, one more every %.0fs of debt", conf.ProofOfWorkMinBits, conf.ProofOfWorkMaxBits, conf.ProofOfWorkDebtSecPerBit)
+	log.Print("")
+	log.Print("EMERGENCY")
+	log.Printf("Emergency enter:    over %.2f of the CPUs for %.0fs (0 to disable)", conf.EmergencyEnterFactor, conf.EmergencyEnterSec)
+	log.Printf("Emergency exit:     under %.2f of the CPUs for %.0fs", conf.EmergencyExitFactor, conf.EmergencyExitSec)
+	log.Printf("Emergency scale:    %.2f", conf.EmergencyScale)
+	log.Printf("Emergency queue:    %d concurrent, timeout %.0fs", conf.EmergencyConcurrency, conf.EmergencyQueueTimeoutSec)
+	log.Print("")
+	log.Print("ADAPTIVE")
+	log.Printf("Adaptive signal:    '%s' (e.g. 'latency', 'cpu', empty to disable)", conf.AdaptiveSignal)
+	log.Printf("Adaptive latency:   p%.0f under %.0fms", conf.AdaptiveLatencyPercentile, conf.AdaptiveLatencyTargetMs)
+	log.Printf("Adaptive idle:      over %.0f%%", conf.AdaptiveIdleTarget)
+	log.Printf("Adaptive scale:     %.2f-%.2f, step %.2f every %.0fs", conf.AdaptiveMinScale, conf.AdaptiveMaxScale, conf.AdaptiveStep, conf.AdaptiveIntervalSec)
+	log.Print("")
+	log.Print("TARPIT")
+	log.Printf("Tarpit debt thresh: %.0fs (0 to disable)", conf.TarpitDebtSec)
+	log.Printf("Tarpit max conns:   %d", conf.TarpitMaxConns)
+	log.Printf("Tarpit throughput:  %d bytes/s for up to %.0fs", conf.TarpitBytesPerSec, conf.TarpitMaxSec)
+	log.Printf("Tarpit buffer:      %d bytes", conf.TarpitBufferBytes)
 	log.Print("")
 	log.Print("COMPUTED")
 	log.Printf("Slash32 max CPU absolute usage:   %.2fcpus", conf.Slash32CPUs)
 	log.Printf("Slash24 max CPU absolute usage:   %.2fcpus", conf.Slash24CPUs)
 	log.Printf("Slash16 max CPU absolute usage:   %.2fcpus", conf.Slash16CPUs)
 	log.Printf("UserAgent max CPU absolute usage: %.2fcpus", conf.UserAgentCPUs)
+	log.Printf("TLSFingerprint max CPU absolute:  %.2fcpus", conf.TLSFingerprintCPUs)
+	log.Printf("HeaderFingerprint max CPU absol.: %.2fcpus", conf.HeaderFingerprintCPUs)
+	log.Printf("ASN max CPU absolute usage:       %.2fcpus", conf.ASNCPUs)
+	log.Printf("Geo max CPU absolute usage:       %.2fcpus", conf.GeoCPUs)
+	log.Printf("Crawler max CPU absolute usage:   %.2fcpus", conf.CrawlerCPUs)
+	log.Printf("QueryCardinality max CPU absol.:  %.2fcpus (%.2fcpus penalised)", conf.QueryCardinalityCPUs, conf.QueryCardinalityPenaltyCPUs)
+	log.Printf("Site max CPU absolute usage:      %.2fcpus", conf.SiteCPUs)
 }
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Priority assigns requests to a class that is treated more leniently than the rest, e.g. the CMS admin, payment
+// callbacks or the load balancer health check. The first rule matching both the path and the method wins.
+type Priority struct {
+	Name            string          `json:"name"`
+	Paths           string          `json:"paths"`
+	Methods         string          `json:"methods"`
+	SkipBuckets     bool            `json:"skipBuckets"`
+	CostFactor      *float64        `json:"costFactor"`
+	QueuePriority   int             `json:"queuePriority"`
+	PathsList       []string        `json:"-"`
+	MethodsMap      map[string]bool `json:"-"`
+	CostFactorValue float64         `json:"-"`
+}
+
+// PriorityFor finds the priority class for the request. Paths are matched by whole leading segments, so "/admin"
+// matches "/admin" and "/admin/pages" but not "/administrator". The path is cleaned first, so "/admin/../search"
+// doesn't sneak into the class. An empty method list matches any method.
+func (conf *Config) PriorityFor(method, requestPath string) (Priority, bool) {
+	cleaned := path.Clean("/" + requestPath)
+	for _, p := range conf.Priorities {
+		if len(p.MethodsMap) > 0 && !p.MethodsMap[method] {
+			continue
+		}
+		for _, prefix := range p.PathsList {
+			if cleaned == prefix || prefix == "/" || strings.HasPrefix(cleaned, prefix+"/") {
+				return p, true
+			}
+		}
+	}
+	return Priority{}, false
+}
+
+func (conf *Config) parsePriorities() error {
+	for i := range conf.Priorities {
+		p := &conf.Priorities[i]
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("Priority%d", i)
+		}
+		p.PathsList = make([]string, 0)
+		for _, prefix := range splitList(p.Paths) {
+			p.PathsList = append(p.PathsList, path.Clean("/"+prefix))
+		}
+		if len(p.PathsList) == 0 {
+			return fmt.Errorf("Priority '%s': no paths", p.Name)
+		}
+		p.MethodsMap = make(map[string]bool)
+		for _, m := range splitList(p.Methods) {
+			p.MethodsMap[strings.ToUpper(m)] = true
+		}
+		p.CostFactorValue = 1.0
+		if p.CostFactor != nil {
+			p.CostFactorValue = *p.CostFactor
+		}
+		// Requests that should not be charged at all must skip the buckets instead.
+		if p.CostFactorValue == 0 {
+			return fmt.Errorf("Priority '%s': cost factor 0, use skipBuckets for requests that should not be charged", p.Name)
+		}
+		if p.CostFactorValue < 0 {
+			return fmt.Errorf("Priority '%s': invalid cost factor %.2f", p.Name, p.CostFactorValue)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"net"
+	"strings"
+)
+
+// Site is a virtual host (or several aliases) served by this tempomat.
+type Site struct {
+	Name           string   `json:"name"`
+	Hosts          string   `json:"hosts"`
+	Backends       string   `json:"backends"`
+	Share          float64  `json:"share"`
+	IsolateBuckets bool     `json:"isolateBuckets"`
+	BackendsList   []string `json:"-"`
+	CPUs           float64  `json:"-"`
+}
+
+// SiteFor finds the site configured for the Host header value.
+func (conf *Config) SiteFor(host string) (Site, bool) {
+	i, ok := conf.SitesMap[NormaliseHost(host)]
+	if !ok {
+		return Site{}, false
+	}
+	return conf.Sites[i], true
+}
+
+// NormaliseHost lowercases the host and strips the port.
+func NormaliseHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func (conf *Config) parseSites(cpuCount float64) {
+	conf.SitesMap = make(map[string]int)
+	conf.SiteCPUs = conf.SiteShare * cpuCount
+	for i := range conf.Sites {
+		s := &conf.Sites[i]
+		hosts := splitList(s.Hosts)
+		if s.Name == "" && len(hosts) > 0 {
+			s.Name = NormaliseHost(hosts[0])
+		}
+		for _, h := range hosts {
+			conf.SitesMap[NormaliseHost(h)] = i
+		}
+
+		s.BackendsList = splitList(s.Backends)
+		s.CPUs = conf.SiteCPUs
+		if s.Share != 0 {
+			s.CPUs = s.Share * cpuCount
+		}
+	}
+}
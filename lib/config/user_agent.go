@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// UserAgentRule normalises the User-Agent for the UserAgent bucket. With Replace, matches of the Pattern are
+// rewritten (with $1 style expansion) and the next rule is applied. With Family, a match ends normalisation
+// and the expanded Family becomes the key.
+type UserAgentRule struct {
+	Pattern string         `json:"pattern"`
+	Replace string         `json:"replace"`
+	Family  string         `json:"family"`
+	Regexp  *regexp.Regexp `json:"-"`
+}
+
+func (conf *Config) parseUserAgentRules() error {
+	for i := range conf.UserAgentRules {
+		rule := &conf.UserAgentRules[i]
+		if rule.Pattern == "" {
+			return fmt.Errorf("User-Agent rule %d has no pattern", i)
+		}
+		var err error
+		if rule.Regexp, err = regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("User-Agent rule %d: %s", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package predict
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// Estimates are only trusted once the pattern has been seen this many times.
+const minSamples = 3
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	idSegment      = regexp.MustCompile(`^(?i)[0-9a-f-]{16,}$`)
+)
+
+// Predictor keeps running averages of the cost of requests, per method and normalised URL pattern.
+type Predictor struct {
+	hash       map[string]Estimate
+	hashMaxLen int
+	sync.RWMutex
+}
+
+type Estimate struct {
+	Method   string
+	Pattern  string
+	AvgCost  float64
+	Samples  int64
+	LastUsed time.Time
+}
+
+func NewPredictor(c config.Config) *Predictor {
+	p := &Predictor{
+		hash: make(map[string]Estimate),
+	}
+	p.SetConfig(c)
+	go p.ticker()
+	return p
+}
+
+func (p *Predictor) SetConfig(c config.Config) {
+	p.Lock()
+	defer p.Unlock()
+	p.hashMaxLen = c.HashMaxLen
+}
+
+// Normalise collapses the parts of the path that usually identify a record, so that e.g. "/product/123" and
+// "/product/456" share the same estimate. The query string is dropped.
+func Normalise(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if numericSegment.MatchString(s) {
+			segments[i] = ":num"
+		} else if idSegment.MatchString(s) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func key(r *http.Request) (string, string) {
+	pattern := Normalise(r.URL.Path)
+	return r.Method + " " + pattern, pattern
+}
+
+// Predict returns the expected cost of the request in CPU-seconds, if there is enough history for it.
+func (p *Predictor) Predict(r *http.Request) (float64, bool) {
+	k, _ := key(r)
+
+	p.RLock()
+	defer p.RUnlock()
+	e, ok := p.hash[k]
+	if !ok || e.Samples < minSamples {
+		return 0, false
+	}
+	return e.AvgCost, true
+}
+
+// Observe feeds the actual cost of the request back into the estimate.
+func (p *Predictor) Observe(r *http.Request, cost float64) {
+	k, pattern := key(r)
+
+	p.Lock()
+	defer p.Unlock()
+	e, ok := p.hash[k]
+	if !ok {
+		e = Estimate{
+			Method:  r.Method,
+			Pattern: pattern,
+			AvgCost: cost,
+		}
+	}
+
+	e.AvgCost -= e.AvgCost / 10
+	e.AvgCost += cost / 10
+	e.Samples++
+	e.LastUsed = time.Now()
+	p.hash[k] = e
+}
+
+func (p *Predictor) Estimates() []Estimate {
+	p.RLock()
+	defer p.RUnlock()
+
+	l := make([]Estimate, 0, len(p.hash))
+	for _, e := range p.hash {
+		l = append(l, e)
+	}
+	return l
+}
+
+// Not concurrency safe.
+func (p *Predictor) truncate(truncatedSize int) {
+	l := make([]Estimate, 0, len(p.hash))
+	for _, e := range p.hash {
+		l = append(l, e)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].LastUsed.After(l[j].LastUsed) })
+
+	newHash := make(map[string]Estimate)
+	for i := 0; i < truncatedSize && i < len(l); i++ {
+		newHash[l[i].Method+" "+l[i].Pattern] = l[i]
+	}
+	p.hash = newHash
+}
+
+func (p *Predictor) ticker() {
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		p.Lock()
+		p.truncate(p.hashMaxLen)
+		p.Unlock()
+	}
+}
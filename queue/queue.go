@@ -0,0 +1,128 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Queue admits a limited number of requests at once. The others wait, and are admitted by priority (higher
+// first), then in the order they arrived.
+type Queue struct {
+	slots    int
+	inFlight int
+	waiting  waiters
+	seq      uint64
+	sync.Mutex
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+	ready    chan struct{}
+}
+
+func NewQueue(slots int) *Queue {
+	return &Queue{
+		slots: slots,
+	}
+}
+
+// SetSlots changes the number of requests admitted at once. Waiting requests are admitted if there's room.
+func (q *Queue) SetSlots(slots int) {
+	q.Lock()
+	defer q.Unlock()
+	q.slots = slots
+	for q.inFlight < q.slots && q.waiting.Len() > 0 {
+		q.admit()
+	}
+}
+
+// Acquire waits for a free slot. It fails with the context error if the context is done first. Every successful
+// Acquire must be followed by a Release.
+func (q *Queue) Acquire(ctx context.Context, priority int) error {
+	q.Lock()
+	if q.inFlight < q.slots && q.waiting.Len() == 0 {
+		q.inFlight++
+		q.Unlock()
+		return nil
+	}
+	q.seq++
+	w := &waiter{
+		priority: priority,
+		seq:      q.seq,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(&q.waiting, w)
+	q.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		q.Lock()
+		defer q.Unlock()
+		select {
+		case <-w.ready:
+			// Admitted in the meantime, hand the slot over to the next one.
+			q.inFlight--
+			if q.inFlight < q.slots && q.waiting.Len() > 0 {
+				q.admit()
+			}
+		default:
+			heap.Remove(&q.waiting, w.index)
+		}
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) Release() {
+	q.Lock()
+	defer q.Unlock()
+	q.inFlight--
+	if q.inFlight < q.slots && q.waiting.Len() > 0 {
+		q.admit()
+	}
+}
+
+// Waiting returns the number of requests waiting for a slot.
+func (q *Queue) Waiting() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.waiting.Len()
+}
+
+// Not concurrency safe.
+func (q *Queue) admit() {
+	w := heap.Pop(&q.waiting).(*waiter)
+	q.inFlight++
+	close(w.ready)
+}
+
+// waiters is a heap of waiting requests.
+type waiters []*waiter
+
+func (h waiters) Len() int { return len(h) }
+func (h waiters) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h waiters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *waiters) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+func (h *waiters) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return w
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+)
+
+// tarpit caps the number of responses being trickled at once, so the goroutines, connections and buffers it ties
+// up stay bounded. Past the cap, offenders are held as usual.
+type tarpit struct {
+	slots chan struct{}
+}
+
+func newTarpit(max int) *tarpit {
+	return &tarpit{
+		slots: make(chan struct{}, max),
+	}
+}
+
+func (t *tarpit) Acquire() bool {
+	select {
+	case t.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *tarpit) Release() {
+	<-t.slots
+}
+
+func (t *tarpit) Count() int {
+	return len(t.slots)
+}
+
+// debtFor returns the longest wait the request's keys would face for a free reservation.
+func debtFor(r *http.Request) time.Duration {
+	var debt time.Duration
+	for _, b := range bucketsFor(r) {
+		if d := b.Debt(r); d > debt {
+			debt = d
+		}
+	}
+	return debt
+}
+
+// tarpitBuffer holds back the backend response, so the backend connection and any queue slot are freed before
+// the response is trickled to the client. Responses over the cap are passed through at full speed instead.
+type tarpitBuffer struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	max         int
+	passthrough bool
+}
+
+func newTarpitBuffer(w http.ResponseWriter, max int) *tarpitBuffer {
+	return &tarpitBuffer{
+		ResponseWriter: w,
+		header:         make(http.Header),
+		max:            max,
+	}
+}
+
+func (b *tarpitBuffer) Header() http.Header {
+	if b.passthrough {
+		return b.ResponseWriter.Header()
+	}
+	return b.header
+}
+
+func (b *tarpitBuffer) WriteHeader(status int) {
+	if b.passthrough {
+		b.ResponseWriter.WriteHeader(status)
+		return
+	}
+	// Informational responses are not worth passing on.
+	if b.status == 0 && status >= 200 {
+		b.status = status
+	}
+}
+
+func (b *tarpitBuffer) Write(p []byte) (int, error) {
+	if b.passthrough {
+		return b.ResponseWriter.Write(p)
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.body.Len()+len(p) > b.max {
+		if err := b.spill(); err != nil {
+			return 0, err
+		}
+		return b.ResponseWriter.Write(p)
+	}
+	return b.body.Write(p)
+}
+
+// Streaming responses are buffered all the same, flushing only makes sense once passing through.
+func (b *tarpitBuffer) Flush() {
+	if !b.passthrough {
+		return
+	}
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Buffered tells whether the whole response is still held back.
+func (b *tarpitBuffer) Buffered() bool {
+	return !b.passthrough
+}
+
+// spill sends what has been buffered so far, and switches to passing the rest through.
+func (b *tarpitBuffer) spill() error {
+	b.passthrough = true
+	b.writeHeader()
+	_, err := b.ResponseWriter.Write(b.body.Bytes())
+	b.body.Reset()
+	return err
+}
+
+func (b *tarpitBuffer) writeHeader() {
+	header := b.ResponseWriter.Header()
+	for name, values := range b.header {
+		header[name] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	b.ResponseWriter.WriteHeader(b.status)
+}
+
+// Trickle sends the buffered response a few bytes per second. Once maxDuration has passed, the rest is sent at
+// full speed.
+func (b *tarpitBuffer) Trickle(ctx context.Context, bytesPerSec int, maxDuration time.Duration) error {
+	b.writeHeader()
+	_, err := newTrickleWriter(b.ResponseWriter, ctx, bytesPerSec, maxDuration).Write(b.body.Bytes())
+	return err
+}
+
+// trickleWriter sends the response a few bytes per second. Once maxDuration has passed, the rest is sent at
+// full speed, so a single response can't occupy a slot forever.
+type trickleWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	bytesPerSec int
+	deadline    time.Time
+}
+
+func newTrickleWriter(w http.ResponseWriter, ctx context.Context, bytesPerSec int, maxDuration time.Duration) *trickleWriter {
+	if bytesPerSec < 1 {
+		bytesPerSec = 1
+	}
+	return &trickleWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		bytesPerSec:    bytesPerSec,
+		deadline:       time.Now().Add(maxDuration),
+	}
+}
+
+func (w *trickleWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if time.Now().After(w.deadline) {
+			n, err := w.ResponseWriter.Write(p[written:])
+			return written + n, err
+		}
+
+		end := written + w.bytesPerSec
+		if end > len(p) {
+			end = len(p)
+		}
+		n, err := w.ResponseWriter.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.Flush()
+
+		select {
+		case <-w.ctx.Done():
+			return written, w.ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	return written, nil
+}
+
+func (w *trickleWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *trickleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -15,7 +15,10 @@ import (
 )
 
 type configuration struct {
-	Bucket string `description:"Name of the bucket to dump"`
+	Bucket      string `description:"Name of the bucket to dump"`
+	Predictions bool   `description:"Dump the cost predictor state instead of a bucket"`
+	Backends    bool   `description:"Dump the backend pool state instead of a bucket"`
+	Rates       bool   `description:"Dump the effective rate of each bucket instead of a bucket"`
 }
 
 var conf configuration
@@ -36,7 +39,7 @@ func main() {
 	flaeg := flaeg.New(rootCmd, os.Args[1:])
 
 	if err := flaeg.Run(); err != nil {
-		log.Fatal("Error reading flags: %s", err)
+		log.Fatalf("Error reading flags: %s", err)
 	}
 
 	client, err := rpc.DialHTTP("tcp", "127.0.0.1:29999")
@@ -44,6 +47,21 @@ func main() {
 		log.Fatal("Failed to dial server:", err)
 	}
 
+	if conf.Predictions {
+		dumpPredictions(client)
+		return
+	}
+
+	if conf.Backends {
+		dumpBackends(client)
+		return
+	}
+
+	if conf.Rates {
+		dumpRates(client)
+		return
+	}
+
 	dump := make(api.DumpList, 0)
 	args := api.DumpArgs{
 		BucketName: conf.Bucket,
@@ -80,6 +98,80 @@ func main() {
 	table.Render()
 }
 
+func dumpPredictions(client *rpc.Client) {
+	predictions := make(api.PredictionList, 0)
+	err := client.Call("TempomatAPI.Predictions", &api.PredictionsArgs{}, &predictions)
+	if err != nil {
+		log.Fatal("Call error:", err)
+	}
+
+	sort.Slice(predictions, func(i, j int) bool { return predictions[i].AvgCost > predictions[j].AvgCost })
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Cpu[s]", "Samples", "Last[s]", "Method", "Pattern"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+
+	for _, p := range predictions {
+		table.Append([]string{
+			fmt.Sprintf("%.3f", p.AvgCost),
+			fmt.Sprintf("%d", p.Samples),
+			fmt.Sprintf("%.0f", time.Now().Sub(p.LastUsed).Seconds()),
+			p.Method,
+			p.Pattern,
+		})
+	}
+
+	table.Render()
+}
+
+func dumpBackends(client *rpc.Client) {
+	backends := make(api.BackendList, 0)
+	err := client.Call("TempomatAPI.Backends", &api.BackendsArgs{}, &backends)
+	if err != nil {
+		log.Fatal("Call error:", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Healthy", "Latency[s]", "Outstanding", "Requests", "URL"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+
+	for _, b := range backends {
+		table.Append([]string{
+			fmt.Sprintf("%t", b.Healthy),
+			fmt.Sprintf("%.3f", b.AvgLatency.Seconds()),
+			fmt.Sprintf("%d", b.Outstanding),
+			fmt.Sprintf("%d", b.Requests),
+			b.URL,
+		})
+	}
+
+	table.Render()
+}
+
+func dumpRates(client *rpc.Client) {
+	rates := make(api.RateList, 0)
+	err := client.Call("TempomatAPI.Rates", &api.RatesArgs{}, &rates)
+	if err != nil {
+		log.Fatal("Call error:", err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Rate[cpus]", "Bucket"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+
+	for _, r := range rates {
+		table.Append([]string{
+			fmt.Sprintf("%.3f", r.Rate),
+			r.Bucket,
+		})
+	}
+
+	table.Render()
+}
+
 func truncateString(str string, num int) string {
 	out := str
 	if len(str) > num {
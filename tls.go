@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// certStore picks the certificate by SNI. Certificates are reloaded on SIGHUP, so renewed certificates can be
+// picked up without a restart.
+type certStore struct {
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+	sync.RWMutex
+}
+
+func newCertStore() *certStore {
+	return &certStore{
+		byName: make(map[string]*tls.Certificate),
+	}
+}
+
+// Load replaces all certificates. On error, the previous certificates are kept.
+func (s *certStore) Load(c config.Config) error {
+	byName := make(map[string]*tls.Certificate)
+	var fallback *tls.Certificate
+
+	for _, pair := range c.TLSCertificates {
+		cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+		if err != nil {
+			return fmt.Errorf("Unable to load certificate '%s': %s", pair.Cert, err)
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("Unable to parse certificate '%s': %s", pair.Cert, err)
+		}
+
+		if fallback == nil {
+			fallback = &cert
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			byName[strings.ToLower(name)] = &cert
+		}
+	}
+
+	if fallback == nil {
+		return fmt.Errorf("No TLS certificates configured")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.byName = byName
+	s.fallback = fallback
+	return nil
+}
+
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	name := strings.ToLower(hello.ServerName)
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return s.fallback, nil
+}
+
+func listenTLS(handler http.Handler, port int) error {
+	var err error
+	tlsListener, err = inheritedListener(tlsListenerFd, fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+
+	// TLS is terminated by the header order listener rather than the server, so that it sees the decrypted
+	// request heads. "h2" has to be listed for the server to set up HTTP/2.
+	tlsServer = &http.Server{
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			GetCertificate:     certificates.GetCertificate,
+			GetConfigForClient: tlsFingerprints.GetConfigForClient,
+			NextProtos:         []string{"h2", "http/1.1"},
+		},
+		ConnState:   tlsFingerprints.ConnState,
+		ConnContext: headerOrders.ConnContext,
+	}
+	listener := headerOrders.TLSListener(tlsListener, tlsServer.TLSConfig.Clone())
+	go func() {
+		if err := tlsServer.Serve(listener); err != http.ErrServerClosed {
+			stderrLog.Printf("%s\n", err)
+			os.Exit(1)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mateusz/tempomat/cost"
+)
+
+// timingTransport records when the backend response headers and body arrive.
+type timingTransport struct {
+	http.RoundTripper
+}
+
+func (t *timingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(r)
+	timings := cost.TimingsFromContext(r.Context())
+	if timings == nil || err != nil {
+		return resp, err
+	}
+
+	timings.BackendHeaders = time.Now()
+	// ReverseProxy needs the body of an upgraded connection to be writable, so it's left alone.
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, err
+	}
+	done, _ := r.Context().Value(backendDoneKey{}).(func())
+	resp.Body = &timingBody{
+		ReadCloser: resp.Body,
+		timings:    timings,
+		done:       done,
+	}
+	return resp, err
+}
+
+type backendDoneKey struct{}
+
+// withBackendDone registers a function to run once the backend response body has been read in full or closed,
+// which may be well before it has reached a slow client. It may run more than once.
+func withBackendDone(ctx context.Context, done func()) context.Context {
+	return context.WithValue(ctx, backendDoneKey{}, done)
+}
+
+type timingBody struct {
+	io.ReadCloser
+	timings *cost.Timings
+	done    func()
+}
+
+func (b *timingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF && b.timings.BackendDone.IsZero() {
+		b.timings.BackendDone = time.Now()
+		if b.done != nil {
+			b.done()
+		}
+	}
+	return n, err
+}
+
+func (b *timingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.done != nil {
+		b.done()
+	}
+	return err
+}
+
+// uploadBody measures the time spent blocked reading the request body, which is mostly the client sending it.
+// It's read by the transport, possibly after the handler has returned, hence the atomic counter.
+type uploadBody struct {
+	io.ReadCloser
+	waited int64
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.waited, int64(time.Since(start)))
+	return n, err
+}
+
+func (b *uploadBody) Waited() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.waited))
+}
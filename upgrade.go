@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Set in the environment of the new binary on upgrade to the number of inherited listeners, which start at fd 3.
+const inheritEnv = "TEMPOMAT_INHERIT_LISTENERS"
+
+// Set in the environment of the new binary on upgrade to the fd of a pipe, which it writes to once it's listening.
+const readyEnv = "TEMPOMAT_READY_FD"
+
+// How long the new binary has to get ready before the upgrade is abandoned.
+const readyTimeout = 30 * time.Second
+
+const (
+	proxyListenerFd = 3
+	rpcListenerFd   = 4
+	tlsListenerFd   = 5
+)
+
+var proxyServer *http.Server
+var rpcServer *http.Server
+var tlsServer *http.Server
+var proxyListener net.Listener
+var rpcListener net.Listener
+var tlsListener net.Listener
+var shutdownDone = make(chan struct{})
+
+// inheritedListener picks up the listener passed down by the previous binary, or opens a new one.
+func inheritedListener(fd int, addr string) (net.Listener, error) {
+	inherited, _ := strconv.Atoi(os.Getenv(inheritEnv))
+	if fd-proxyListenerFd >= inherited {
+		return net.Listen("tcp", addr)
+	}
+
+	f := os.NewFile(uintptr(fd), addr)
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// shutdownHandler drains in-flight requests on SIGTERM/SIGINT. On SIGUSR2 it first starts a new copy of the
+// binary and hands it the listening sockets, so no connections are refused during the upgrade.
+func shutdownHandler() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2)
+	for sig := range c {
+		if sig == syscall.SIGUSR2 {
+			stdoutLog.Print("SIGUSR2 received, starting new binary\n")
+			if err := upgrade(); err != nil {
+				stderrLog.Printf("Unable to upgrade: %s\n", err)
+				continue
+			}
+		} else {
+			stdoutLog.Printf("%s received, shutting down\n", sig)
+		}
+
+		shutdown()
+		return
+	}
+}
+
+func upgrade() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	listeners := []net.Listener{proxyListener, rpcListener}
+	if tlsListener != nil {
+		listeners = append(listeners, tlsListener)
+	}
+
+	files := make([]*os.File, 0, len(listeners))
+	for _, l := range listeners {
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("Listener %s can't be handed over", l.Addr())
+		}
+		f, err := tl.File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	// The old binary keeps serving until the new one says it's ready, in case it fails to start, e.g. on a bad
+	// config. If it dies, the pipe is closed without a word.
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", inheritEnv, len(files)),
+		fmt.Sprintf("%s=%d", readyEnv, proxyListenerFd+len(files)),
+	)
+	cmd.ExtraFiles = append(files, readyWriter)
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	stdoutLog.Printf("New binary started with pid %d\n", cmd.Process.Pid)
+
+	signalled := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		signalled <- err
+	}()
+	select {
+	case err := <-signalled:
+		if err != nil {
+			cmd.Wait()
+			return fmt.Errorf("New binary exited before it was ready")
+		}
+	case <-time.After(readyTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("New binary not ready after %s", readyTimeout)
+	}
+	stdoutLog.Print("New binary ready\n")
+	return nil
+}
+
+// signalReady tells the old binary that we are listening, so it can start draining.
+func signalReady() {
+	fd, err := strconv.Atoi(os.Getenv(readyEnv))
+	os.Unsetenv(readyEnv)
+	if err != nil {
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		stderrLog.Printf("Unable to signal readiness: %s\n", err)
+	}
+}
+
+func shutdown() {
+	confMutex.RLock()
+	timeout := time.Duration(conf.ShutdownTimeoutSec*1000) * time.Millisecond
+	confMutex.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := proxyServer.Shutdown(ctx); err != nil {
+		stderrLog.Printf("Failed to drain in-flight requests: %s\n", err)
+	}
+	if tlsServer != nil {
+		if err := tlsServer.Shutdown(ctx); err != nil {
+			stderrLog.Printf("Failed to drain in-flight TLS requests: %s\n", err)
+		}
+	}
+	if err := rpcServer.Shutdown(ctx); err != nil {
+		stderrLog.Printf("Failed to shut down RPC server: %s\n", err)
+	}
+	close(shutdownDone)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/mateusz/tempomat/backend"
+	"github.com/mateusz/tempomat/lib/config"
+)
+
+// vhosts routes requests to the backend pool of the site matching the Host header. Sites without their
+// own backends, and unknown hosts, go to the default pool.
+type vhosts struct {
+	conf      config.Config
+	fallback  *backend.Pool
+	pools     map[string]*backend.Pool
+	transport http.RoundTripper
+	sync.RWMutex
+}
+
+func newVhosts(c config.Config, transport http.RoundTripper) (*vhosts, error) {
+	fallback, err := backend.NewPool(c, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &vhosts{
+		fallback:  fallback,
+		pools:     make(map[string]*backend.Pool),
+		transport: transport,
+	}
+	if err := v.SetConfig(c); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *vhosts) SetConfig(c config.Config) error {
+	if err := v.fallback.SetConfig(c); err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	pools := make(map[string]*backend.Pool)
+	created := make([]*backend.Pool, 0)
+	for _, s := range c.Sites {
+		if len(s.BackendsList) == 0 {
+			continue
+		}
+
+		siteConf := c
+		siteConf.BackendsList = s.BackendsList
+		if p, ok := v.pools[s.Name]; ok {
+			if err := p.SetConfig(siteConf); err != nil {
+				stopPools(created)
+				return err
+			}
+			pools[s.Name] = p
+			continue
+		}
+
+		p, err := backend.NewPool(siteConf, v.transport)
+		if err != nil {
+			stopPools(created)
+			return err
+		}
+		pools[s.Name] = p
+		created = append(created, p)
+	}
+
+	// Pools of sites that are gone, or no longer have their own backends, would keep health checking forever.
+	for name, p := range v.pools {
+		if _, ok := pools[name]; !ok {
+			p.Stop()
+		}
+	}
+
+	v.conf = c
+	v.pools = pools
+	return nil
+}
+
+func stopPools(pools []*backend.Pool) {
+	for _, p := range pools {
+		p.Stop()
+	}
+}
+
+func (v *vhosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.pool(r.Host).ServeHTTP(w, r)
+}
+
+func (v *vhosts) pool(host string) *backend.Pool {
+	v.RLock()
+	defer v.RUnlock()
+
+	if s, ok := v.conf.SiteFor(host); ok {
+		if p, ok := v.pools[s.Name]; ok {
+			return p
+		}
+	}
+	return v.fallback
+}
+
+// Capacity returns the healthy fraction of the pool serving the site, or of the default pool for "".
+func (v *vhosts) Capacity(site string) float64 {
+	v.RLock()
+	defer v.RUnlock()
+
+	if p, ok := v.pools[site]; ok {
+		return p.Capacity()
+	}
+	return v.fallback.Capacity()
+}
+
+func (v *vhosts) Statuses() []backend.Status {
+	v.RLock()
+	defer v.RUnlock()
+
+	l := v.fallback.Statuses()
+	for _, p := range v.pools {
+		l = append(l, p.Statuses()...)
+	}
+	return l
+}
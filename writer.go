@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mateusz/tempomat/cost"
+)
+
+// statusWriter remembers the status code and the time of the first byte sent to the client, so the response
+// can be costed afterwards.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	timings *cost.Timings
+}
+
+func newStatusWriter(w http.ResponseWriter, timings *cost.Timings) *statusWriter {
+	return &statusWriter{
+		ResponseWriter: w,
+		status:         http.StatusOK,
+		timings:        timings,
+	}
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.timings.FirstByte.IsZero() {
+		w.timings.FirstByte = time.Now()
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}